@@ -3,11 +3,21 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"math"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,13 +27,101 @@ import (
 	"github.com/charmbracelet/lipgloss"
 	"github.com/rhogenson/ccp/internal/cp"
 	"github.com/rhogenson/ccp/internal/render"
+	"github.com/rhogenson/ccp/internal/wfs"
+	"github.com/rhogenson/ccp/internal/wfs/ftpfs"
 	"github.com/rhogenson/ccp/internal/wfs/osfs"
 	"github.com/rhogenson/ccp/internal/wfs/sftpfs"
+	"github.com/rhogenson/ccp/internal/wfs/tarfs"
+	"github.com/rhogenson/ccp/internal/wfs/zipfs"
 	"github.com/rhogenson/deque"
 	"golang.org/x/term"
 )
 
-var f = flag.Bool("f", false, "if an existing destination file cannot be opened, remove it and try again")
+var (
+	c        = flag.Bool("c", false, "verify copied files by comparing SHA-256 checksums")
+	touch    = flag.Bool("times-only", false, "copy no data, only setting the times of files already in TARGET whose modification times differ from their sources")
+	compare  = flag.Bool("checksum-only", false, "instead of copying, compare the sources with TARGET by SHA-256 checksum and list the files that differ, are missing, or are extra")
+	move     = flag.Bool("move", false, "move files instead of copying them, renaming them where possible")
+	rmSrc    = flag.Bool("remove-source-files", false, "remove each source file after it's copied, and each source directory once empty")
+	dryRun   = flag.Bool("dry-run", false, "show what would be copied without modifying the destination")
+	H        = flag.Bool("H", false, "follow symbolic links named as sources")
+	L        = flag.Bool("L", false, "follow all symbolic links")
+	f        = flag.Bool("f", false, "if an existing destination file cannot be opened, remove it and try again")
+	D        = flag.Bool("D", false, "create any missing parent directories of TARGET, like install -D")
+	n        = flag.Bool("n", false, "do not overwrite an existing file (overrides -f)")
+	existing = flag.Bool("ignore-existing", false, "leave anything that already exists in the target untouched, whatever its size or time, and only create new files; the same as -n, and overrides -f, -interactive, -update, and -backup")
+	p        = flag.Bool("p", false, "preserve access and modification times")
+	chmod    = flag.String("chmod", "", "apply the comma-separated mode `CHANGES` to copies instead of the source modes, e.g. D755,F644 or u+rwX,go-w")
+	preserve = flag.String("preserve", "", "preserve the comma-separated `ATTRS`: times, owner, links")
+	jsonOut  = flag.Bool("json", false, "report progress as newline-delimited JSON events on stdout")
+	jobs     = flag.Int("jobs", cp.DefaultJobs, "copy up to `N` files concurrently; 1 copies files in order")
+	bufSize  = flag.String("buffer-size", "1MiB", "copy each file through a buffer of `SIZE`, e.g. 256k or 4M; buffers take about -jobs times this much memory")
+	xattrs   = flag.Bool("xattrs", false, "copy extended attributes")
+	acls     = flag.Bool("acls", false, "copy POSIX ACLs, including the default ACLs of directories")
+	q        = flag.Bool("q", false, "don't show progress; only print errors")
+	v        = flag.Bool("v", false, "print the name of each file as it's copied")
+	resume   = flag.Bool("resume", false, "continue partially copied files instead of starting over")
+	update   = flag.Bool("update", false, "skip files whose destination has the same size and is not older")
+	always   = flag.Bool("always-copy", false, "copy files even if the target has the same size and modification time, which are otherwise skipped with -p")
+	minSize  = flag.String("min-size", "", "skip files smaller than `SIZE`, e.g. 10k, 100M, or 1GiB")
+	maxSize  = flag.String("max-size", "", "skip files larger than `SIZE`, e.g. 10k, 100M, or 1GiB")
+	newer    = flag.String("newer-than", "", "only copy files modified after `TIME`, a date like 2024-01-01 or a duration ago like 7d or 2h")
+	del      = flag.Bool("delete", false, "delete files in the target directory that aren't in the source directory")
+	sparse   = flag.Bool("sparse", false, "skip holes in sparse source files, making the copies sparse too")
+	reflink  = flag.String("reflink", "auto", "whether to clone local files, sharing their data blocks on file systems like Btrfs and XFS: `WHEN` is auto, always, or never")
+	safe     = flag.Bool("copy-links-safely", false, "make absolute symlinks within a source directory relative, and warn about symlinks pointing outside it")
+	slash    = flag.Bool("trailing-slash", false, "copy the contents of a source directory ending in /, not the directory itself, like rsync")
+	specials = flag.Bool("specials", false, "recreate FIFOs, sockets, and device nodes instead of skipping them")
+	failFast = flag.Bool("fail-fast", false, "stop at the first error instead of copying as much as possible")
+	atomic   = flag.Bool("atomic", false, "write each file to a temporary name and rename it into place, so no partial copies are ever visible")
+	tempDir  = flag.String("temp-dir", "", "write the temporary files of -atomic to `DIR` on the target's file system instead of next to each file; implies -atomic")
+	fsync    = flag.Bool("fsync", false, "commit each copied file, and the directories they're in, to disk before finishing")
+
+	interactive   = flag.Bool("interactive", false, "prompt before overwriting an existing file (-n overrides this)")
+	backup        = flag.String("backup", "none", "back up existing files before overwriting them: `CONTROL` is none, simple, numbered, or existing (numbered if there are numbered backups already)")
+	suffix        = flag.String("suffix", "~", "add `SUFFIX` to the names of simple backups")
+	manifestFile  = flag.String("manifest", "", "write the source, target, size, mode, and with -c the SHA-256 checksum of each file copied to `FILE`, as JSON lines if it ends in .json or .jsonl and as tab-separated values otherwise")
+	progressEvery = flag.Duration("progress-every", 0, "instead of drawing a progress bar, print a plain progress line every `DURATION`, e.g. for CI logs")
+	logFile       = flag.String("log-file", "", "append debugging logs of connections, retries, and files copied to `FILE`")
+	logLevel      = flag.String("log-level", "", "log messages at `LEVEL` or above: debug, info, warn, or error; logs go to stderr without -log-file (default info)")
+
+	P        = flag.Int("P", 0, "connect to `port` on remote hosts (default 22)")
+	sessions = flag.Int("sessions", 1, "open `N` SFTP sessions to each remote host")
+	retries  = flag.Int("retries", 3, "retry remote operations up to `N` times after network errors")
+	rsh      = flag.String("rsh", "", "connect to remote hosts by running `COMMAND`, such as ssh or ssh -J jumphost, instead of with ccp's own SSH client; -P, -i, and -o are passed on, and the other SSH options are ignored")
+
+	sftpMaxPacket = flag.Int("sftp-max-packet", 32768, "read and write up to `BYTES` in each SFTP request; larger sizes mean fewer round trips, but some servers reject more than the default")
+	sftpRequests  = flag.Int("sftp-requests", 64, "keep up to `N` SFTP requests in flight for each file; more help on high latency links, at the cost of memory")
+
+	strictHostKeyChecking = flag.String("strict-host-key-checking", "no", "what to do with unknown SSH host keys: yes rejects them, ask prompts, no adds them to known_hosts")
+	knownHosts            = flag.String("known-hosts", "", "verify SSH host keys against `FILE` (default ~/.ssh/known_hosts)")
+	serverAliveInterval   = flag.Duration("server-alive-interval", 15*time.Second, "send SSH keepalives after `DURATION` of inactivity; 0 disables them")
+	connectTimeout        = flag.Duration("connect-timeout", 0, "give up connecting to an SSH host after `DURATION` (default no limit beyond the system's)")
+	identityFiles         []string          // Set by -i
+	sshOptions            map[string]string // Set by -o
+
+	filesFrom  = flag.String("files-from", "", "read newline-separated sources from `FILE` (- for stdin)")
+	filesFrom0 = flag.String("files-from0", "", "read NUL-separated sources from `FILE` (- for stdin)")
+	glob       = flag.Bool("glob", false, "expand braces like {a,b} and wildcards in each SOURCE, local or remote, for when no shell has")
+)
+
+func init() {
+	flag.Func("i", "authenticate with the SSH private key in `FILE` instead of the agent or ~/.ssh; may be repeated", func(file string) error {
+		identityFiles = append(identityFiles, file)
+		return nil
+	})
+	flag.Func("o", "set the SSH `OPTION`, given as Key=Value like ssh -o; may be repeated", func(s string) error {
+		key, value, err := sftpfs.ParseOption(s)
+		if err != nil {
+			return err
+		}
+		if sshOptions == nil {
+			sshOptions = make(map[string]string)
+		}
+		sshOptions[key] = value
+		return nil
+	})
+}
 
 var warningStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("3")).Render
 
@@ -32,6 +130,38 @@ type measurement struct {
 	i int64
 }
 
+// A speedometer estimates the speed of a copy and the time left from
+// measurements of its progress over the last couple of minutes.
+type speedometer struct {
+	measurements deque.Deque[measurement]
+	speed        float64       // Bytes per second, or -1 if not known yet
+	eta          time.Duration // -1 if not known yet
+}
+
+func newSpeedometer() *speedometer {
+	return &speedometer{speed: -1, eta: -1}
+}
+
+// measure records that current bytes out of max had been copied at now, and
+// updates the speed and ETA.
+func (s *speedometer) measure(now time.Time, current, max int64) {
+	for s.measurements.Len() > 1 && now.Sub(s.measurements.At(0).t) > 2*time.Minute {
+		s.measurements.PopFront()
+	}
+	s.measurements.PushBack(measurement{now, current})
+
+	first := s.measurements.At(0)
+	if deltaT := now.Sub(first.t); deltaT > 0 {
+		s.speed = float64(current-first.i) / deltaT.Seconds()
+	}
+	if max > 0 {
+		if delta := current - first.i; delta != 0 {
+			deltaT := now.Sub(first.t)
+			s.eta = time.Duration(float64(max-current) / float64(delta) * float64(deltaT))
+		}
+	}
+}
+
 // progressUpdater implements the cp.Progress interface.
 type progressUpdater struct {
 	mu          sync.Mutex
@@ -39,7 +169,19 @@ type progressUpdater struct {
 	current     int64  // Current bytes copied
 	copyingFrom string // File currently being copied
 	copyingTo   string
-	errs        []error // Any errors encountered
+	// The size of the file being copied, and how much of it has been
+	// copied. With several files copied at once, bytes from the others
+	// are counted too, so fileCurrent is only approximate.
+	fileSize    int64
+	fileCurrent int64
+	errs        []error // Any errors or warnings encountered
+	failed      bool    // Whether any of errs is an error rather than a warning
+	verbose     bool
+	copied      []string // Files copied since the last frame, if verbose
+	files       int      // Number of regular files copied
+	maxFiles    int      // Total regular files to copy
+	// The summary of the copy, once it's finished.
+	result cp.Result
 }
 
 func (pu *progressUpdater) Max(n int64) {
@@ -48,10 +190,23 @@ func (pu *progressUpdater) Max(n int64) {
 	pu.max = n
 }
 
+func (pu *progressUpdater) MaxFiles(n int) {
+	pu.mu.Lock()
+	defer pu.mu.Unlock()
+	pu.maxFiles = n
+}
+
+func (pu *progressUpdater) Summary(r cp.Result) {
+	pu.mu.Lock()
+	defer pu.mu.Unlock()
+	pu.result = r
+}
+
 func (pu *progressUpdater) Progress(n int64) {
 	pu.mu.Lock()
 	defer pu.mu.Unlock()
 	pu.current += n
+	pu.fileCurrent = min(pu.fileCurrent+n, pu.fileSize)
 }
 
 func abbreviatePath(p string) string {
@@ -64,23 +219,70 @@ func abbreviatePath(p string) string {
 	return strings.Join(parts, string(filepath.Separator))
 }
 
-func (pu *progressUpdater) FileStart(from, to string) {
+// formatBytes formats n as a human-readable number of bytes, e.g. 4.2 GB.
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
+
+func (pu *progressUpdater) FileStart(from, to string, size int64) {
 	pu.mu.Lock()
 	defer pu.mu.Unlock()
 	pu.copyingFrom = from
 	pu.copyingTo = to
+	pu.fileSize = size
+	pu.fileCurrent = 0
+}
+
+func (pu *progressUpdater) FileDone(src, dst string, err error) {
+	if err != nil {
+		pu.Error(err)
+		return
+	}
+	pu.mu.Lock()
+	defer pu.mu.Unlock()
+	pu.files++
+	if pu.verbose {
+		pu.copied = append(pu.copied, src+" -> "+dst)
+	}
 }
 
 func (pu *progressUpdater) Error(err error) {
 	pu.mu.Lock()
 	defer pu.mu.Unlock()
 	pu.errs = append(pu.errs, err)
+	pu.failed = true
+}
+
+func (pu *progressUpdater) Warning(err error) {
+	pu.mu.Lock()
+	defer pu.mu.Unlock()
+	pu.errs = append(pu.errs, err)
 }
 
 // splitHostPath splits an scp target into host and path, e.g. user@host:/path/
 // If the user wants to copy a local file that has a colon in it, they can
 // qualify it with the directory name, e.g. ./file:with:colons.
 func splitHostPath(target string) (string, string) {
+	// An IPv6 address is in brackets, since it has colons of its own,
+	// e.g. user@[2001:db8::1]:/path. The brackets aren't part of the host.
+	user, rest := "", target
+	if i := strings.IndexAny(target, "@:/"); i >= 0 && target[i] == '@' {
+		user, rest = target[:i+1], target[i+1:]
+	}
+	if strings.HasPrefix(rest, "[") {
+		if end := strings.Index(rest, "]:"); end >= 0 {
+			return user + rest[1:end], rest[end+2:]
+		}
+	}
 	i := strings.IndexAny(target, ":/")
 	if i < 0 || target[i] == '/' {
 		return "", target
@@ -88,51 +290,666 @@ func splitHostPath(target string) (string, string) {
 	return target[:i], target[i+1:]
 }
 
-func toFSPath(target string, sftpHosts map[string]*sftpfs.FS) cp.FSPath {
+// ftpURL parses target if it's an FTP URL, e.g. ftp://user@host/path. As in
+// RFC 1738, the path is relative to the login directory unless it starts with
+// a second slash.
+func ftpURL(target string) (u *url.URL, ok bool, err error) {
+	if !strings.HasPrefix(target, "ftp://") {
+		return nil, false, nil
+	}
+	u, err = url.Parse(target)
+	return u, true, err
+}
+
+// ftpHostKey identifies the FTP connection needed for u.
+func ftpHostKey(u *url.URL) string {
+	return u.User.Username() + "@" + u.Host
+}
+
+// toFSPath returns the file system and path named by target. If target is a
+// local archive, the returned closer must be closed to finish writing it.
+func toFSPath(target string, sftpHosts map[string]*sftpfs.FS, ftpHosts map[string]*ftpfs.FS, isDst bool) (cp.FSPath, io.Closer, error) {
+	if target == "-" && isDst {
+		return cp.Stream(os.Stdout), nil, nil
+	}
+	if u, ok, _ := ftpURL(target); ok {
+		path := strings.TrimPrefix(u.Path, "/")
+		if path == "" {
+			path = "."
+		}
+		return cp.FSPath{FS: ftpHosts[ftpHostKey(u)], Path: path}, nil, nil
+	}
 	host, path := splitHostPath(target)
-	if host == "" {
-		return cp.FSPath{FS: osfs.FS{}, Path: path}
+	if host != "" {
+		if path == "" {
+			path = "."
+		}
+		return cp.FSPath{FS: sftpHosts[host], Path: path}, nil, nil
 	}
-	if path == "" {
-		path = "."
+	if strings.HasSuffix(path, ".tar") || strings.HasSuffix(path, ".zip") {
+		fsys, closer, err := openArchive(path, isDst)
+		if err != nil {
+			return cp.FSPath{}, nil, err
+		}
+		return cp.FSPath{FS: fsys, Path: "."}, closer, nil
+	}
+	return cp.FSPath{FS: osfs.FS{}, Path: path}, nil, nil
+}
+
+// rshCommand returns the command that -rsh runs to reach host, like rsync -e:
+// the words of -rsh, ssh options for -P, -i, and -o, then host, and -s sftp to
+// start the SFTP subsystem.
+func rshCommand(host string) []string {
+	cmd := strings.Fields(*rsh)
+	if *P != 0 {
+		cmd = append(cmd, "-p", strconv.Itoa(*P))
+	}
+	for _, f := range identityFiles {
+		cmd = append(cmd, "-i", f)
+	}
+	for _, key := range slices.Sorted(maps.Keys(sshOptions)) {
+		cmd = append(cmd, "-o", key+"="+sshOptions[key])
+	}
+	return append(cmd, host, "-s", "sftp")
+}
+
+// expandGlob expands any wildcards in the source src. That's done for remote
+// sources, since the local shell can't, and for every source with -glob. A
+// path that exists as written, or that matches nothing, is left alone, so that
+// file names containing brackets can still be copied.
+func expandGlob(src cp.FSPath) []cp.FSPath {
+	if !strings.ContainsAny(src.Path, `*?[`) {
+		return []cp.FSPath{src}
+	}
+	if _, err := wfs.Lstat(src.FS, src.Path); err == nil {
+		return []cp.FSPath{src}
+	}
+	var matches []string
+	var err error
+	if _, ok := src.FS.(osfs.FS); ok {
+		matches, err = filepath.Glob(src.Path)
+	} else {
+		matches, err = fs.Glob(src.FS, src.Path)
+	}
+	if err != nil || len(matches) == 0 {
+		return []cp.FSPath{src}
+	}
+	srcs := make([]cp.FSPath, len(matches))
+	for i, m := range matches {
+		srcs[i] = cp.FSPath{FS: src.FS, Path: m}
+	}
+	return srcs
+}
+
+// expandBraces expands the brace patterns in s for -glob, like a shell does:
+// a{b,c}d becomes abd and acd, and patterns can be nested. Braces without a
+// comma between them, like {} or {a}, are left as they are.
+func expandBraces(s string) []string {
+	for i := strings.IndexByte(s, '{'); i >= 0; {
+		depth := 0
+		commas := []int{i}
+		end := -1
+	scan:
+		for j := i + 1; j < len(s); j++ {
+			switch s[j] {
+			case '{':
+				depth++
+			case '}':
+				if depth == 0 {
+					end = j
+					break scan
+				}
+				depth--
+			case ',':
+				if depth == 0 {
+					commas = append(commas, j)
+				}
+			}
+		}
+		if end < 0 || len(commas) == 1 {
+			// Not a pattern, but there may be one inside it.
+			next := strings.IndexByte(s[i+1:], '{')
+			if next < 0 {
+				break
+			}
+			i += 1 + next
+			continue
+		}
+		var expanded []string
+		for k, start := range commas {
+			stop := end
+			if k+1 < len(commas) {
+				stop = commas[k+1]
+			}
+			expanded = append(expanded, expandBraces(s[:i]+s[start+1:stop]+s[end+1:])...)
+		}
+		return expanded
+	}
+	return []string{s}
+}
+
+// An archiveWriter is a file system that writes an archive when closed.
+type archiveWriter interface {
+	wfs.FS
+	io.Closer
+}
+
+// openArchive opens a tar or zip archive to copy files out of, or creates one
+// to copy files into. With -dry-run, the archive is written nowhere, so that an
+// existing file isn't truncated.
+func openArchive(path string, create bool) (wfs.FS, io.Closer, error) {
+	isZip := strings.HasSuffix(path, ".zip")
+	if create {
+		var f io.WriteCloser = nopWriteCloser{io.Discard}
+		if !*dryRun {
+			var err error
+			if f, err = os.Create(path); err != nil {
+				return nil, nil, err
+			}
+		}
+		var w archiveWriter
+		var err error
+		if isZip {
+			w, err = zipfs.NewWriter(f)
+		} else {
+			w, err = tarfs.NewWriter(f)
+		}
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return w, closerFunc(func() error {
+			err := w.Close()
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			return err
+		}), nil
 	}
-	return cp.FSPath{FS: sftpHosts[host], Path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	var r wfs.FS
+	if isZip {
+		r, err = zipfs.NewReader(f, stat.Size())
+	} else {
+		r, err = tarfs.NewReader(f, stat.Size())
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, f, nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// Exit statuses. Any other error, including failing to copy some files, exits
+// with status 1.
+const (
+	exitUsage   = 2 // Bad command line
+	exitConnect = 3 // Couldn't connect or log in to a remote host
+)
+
+// An exitError is an error that makes ccp exit with a particular status.
+type exitError struct {
+	status int
+	err    error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func usageError(format string, a ...any) error {
+	return &exitError{exitUsage, fmt.Errorf(format, a...)}
+}
+
+// errCopyFailed is returned when any file couldn't be copied. The errors have
+// already been reported by then.
+var errCopyFailed = errors.New("exiting with one or more errors")
+
+// readSources reads a list of sources separated by sep from the named file, or
+// from stdin if name is -. Empty entries are ignored.
+func readSources(name string, sep byte) ([]string, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var srcs []string
+	for _, src := range strings.Split(string(data), string(sep)) {
+		if src != "" {
+			srcs = append(srcs, src)
+		}
+	}
+	return srcs, nil
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// askOverwrite asks the user whether to overwrite dst, like cp -i.
+func askOverwrite(dst string) bool {
+	fmt.Fprintf(os.Stderr, "overwrite '%s'? (y/n) ", dst)
+	answer, err := stdin.ReadString('\n')
+	if err != nil {
+		fmt.Fprintln(os.Stderr)
+	}
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "y")
+}
+
+// parseBytes parses a number of bytes with an optional unit, like 100, 10k,
+// 1.5G, or 4MiB. As in formatBytes, k, M, G, and so on are powers of 1000;
+// Ki, Mi, Gi, and so on are powers of 1024.
+func parseBytes(s string) (int64, error) {
+	num := strings.TrimRight(s, "kKMGTPEiB")
+	unit := strings.TrimSuffix(s[len(num):], "B")
+	base := 1000.
+	if u, ok := strings.CutSuffix(unit, "i"); ok {
+		unit, base = u, 1024
+	}
+	exp := 0
+	if unit == "K" {
+		exp = 1
+	} else if unit != "" {
+		exp = strings.Index("kMGTPE", unit) + 1
+	}
+	if len(unit) > 1 || unit != "" && exp == 0 || base == 1024 && exp == 0 {
+		return 0, fmt.Errorf("can't parse size %q", s)
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("can't parse size %q", s)
+	}
+	return int64(n * math.Pow(base, float64(exp))), nil
+}
+
+// parseTime parses an absolute time like 2024-01-01 or 2024-01-01T15:04:05, in
+// the local time zone unless one is given, or a duration before now like 90m,
+// 2h, or 7d.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.ParseFloat(days, 64); err == nil {
+			return now.Add(-time.Duration(n * float64(24*time.Hour))), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.DateOnly, time.DateTime, "2006-01-02T15:04:05", "2006-01-02 15:04", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("can't parse time %q", s)
 }
 
 func run() error {
 	args := flag.Args()
-	if len(args) < 2 {
-		return errors.New("usage error")
+	if len(args) < 2 && (len(args) < 1 || *filesFrom == "" && *filesFrom0 == "") {
+		return usageError("usage error")
 	}
 	srcTargets, dstTarget := args[:len(args)-1], args[len(args)-1]
-	sftpHosts := make(map[string]*sftpfs.FS)
+	for _, list := range []struct {
+		name string
+		sep  byte
+	}{{*filesFrom, '\n'}, {*filesFrom0, 0}} {
+		if list.name == "" {
+			continue
+		}
+		srcs, err := readSources(list.name, list.sep)
+		if err != nil {
+			return err
+		}
+		srcTargets = append(srcTargets, srcs...)
+	}
+	if *glob {
+		var expanded []string
+		for _, tgt := range srcTargets {
+			expanded = append(expanded, expandBraces(tgt)...)
+		}
+		srcTargets = expanded
+	}
+	if len(srcTargets) == 0 {
+		return usageError("no sources to copy")
+	}
+	opts := cp.Options{
+		Force:         *f,
+		NoClobber:     *n || *existing,
+		PreserveTimes: *p,
+		Update:        *update,
+		AlwaysCopy:    *always,
+		Resume:        *resume,
+		Verify:        *c,
+		Jobs:          *jobs,
+		Move:          *move,
+		DryRun:        *dryRun,
+		TimesOnly:     *touch,
+		Parents:       *D,
+		TrailingSlash: *slash,
+		Delete:        *del,
+		FailFast:      *failFast,
+		Atomic:        *atomic || *tempDir != "",
+		TempDir:       *tempDir,
+		Fsync:         *fsync,
+		Sparse:        *sparse,
+		SafeLinks:     *safe,
+		Specials:      *specials,
+		Xattrs:        *xattrs,
+		ACLs:          *acls,
+		FollowRoots:   *H,
+		FollowLinks:   *L,
+	}
+	opts.RemoveSourceFiles = *rmSrc
+	if *interactive {
+		opts.ConfirmOverwrite = askOverwrite
+	}
+	bufBytes, err := parseBytes(*bufSize)
+	if err != nil {
+		return usageError("-buffer-size: %v", err)
+	}
+	if bufBytes < 1 || bufBytes > math.MaxInt32 {
+		return usageError("-buffer-size must be at least 1 byte and less than 2GiB, not %q", *bufSize)
+	}
+	opts.BufferSize = int(bufBytes)
+	for _, limit := range []struct {
+		name  string
+		value string
+		size  *int64
+	}{{"min-size", *minSize, &opts.MinSize}, {"max-size", *maxSize, &opts.MaxSize}} {
+		if limit.value == "" {
+			continue
+		}
+		var err error
+		if *limit.size, err = parseBytes(limit.value); err != nil {
+			return usageError("-%s: %v", limit.name, err)
+		}
+	}
+	if *newer != "" {
+		var err error
+		if opts.NewerThan, err = parseTime(*newer, time.Now()); err != nil {
+			return usageError("-newer-than: %v", err)
+		}
+	}
+	switch *backup {
+	case "none", "off":
+		opts.Backup = cp.NoBackup
+	case "simple", "never":
+		opts.Backup = cp.SimpleBackup
+	case "numbered", "t":
+		opts.Backup = cp.NumberedBackup
+	case "existing", "nil":
+		opts.Backup = cp.ExistingBackup
+	default:
+		return usageError("-backup must be none, simple, numbered, or existing, not %q", *backup)
+	}
+	opts.BackupSuffix = *suffix
+	switch *reflink {
+	case "auto":
+		opts.Reflink = cp.ReflinkAuto
+	case "always":
+		opts.Reflink = cp.ReflinkAlways
+	case "never":
+		opts.Reflink = cp.ReflinkNever
+	default:
+		return usageError("-reflink must be auto, always, or never, not %q", *reflink)
+	}
+	if *chmod != "" {
+		var err error
+		if opts.Chmod, err = cp.ParseChmod(*chmod); err != nil {
+			return usageError("-chmod: %v", err)
+		}
+	}
+	if *preserve != "" {
+		for _, attr := range strings.Split(*preserve, ",") {
+			switch attr {
+			case "times":
+				opts.PreserveTimes = true
+			case "owner":
+				opts.PreserveOwner = true
+			case "links":
+				opts.PreserveLinks = true
+			default:
+				return usageError("unknown attribute for -preserve: %q", attr)
+			}
+		}
+	}
+	var hostKeyChecking sftpfs.HostKeyChecking
+	switch *strictHostKeyChecking {
+	case "yes":
+		hostKeyChecking = sftpfs.Strict
+	case "ask":
+		hostKeyChecking = sftpfs.Ask
+	case "no":
+		hostKeyChecking = sftpfs.AcceptNew
+	default:
+		return usageError("-strict-host-key-checking must be yes, no, or ask, not %q", *strictHostKeyChecking)
+	}
+	logger, logCloser, err := openLog(*logFile, *logLevel)
+	if err != nil {
+		return err
+	}
+	if logCloser != nil {
+		defer logCloser.Close()
+	}
+	opts.Logger = logger
+	ftpHosts := make(map[string]*ftpfs.FS)
+	var sshHosts []string
 	for _, tgt := range append(srcTargets, dstTarget) {
-		host, _ := splitHostPath(tgt)
-		if host == "" || sftpHosts[host] != nil {
+		if u, ok, err := ftpURL(tgt); err != nil {
+			return &exitError{exitUsage, err}
+		} else if ok {
+			key := ftpHostKey(u)
+			if ftpHosts[key] != nil {
+				continue
+			}
+			conn, err := ftpfs.Dial(u.Host, u.User)
+			if err != nil {
+				return &exitError{exitConnect, err}
+			}
+			defer conn.Close()
+			ftpHosts[key] = conn
 			continue
 		}
-		fs, err := sftpfs.Dial(host)
+		if host, _ := splitHostPath(tgt); host != "" && !slices.Contains(sshHosts, host) {
+			sshHosts = append(sshHosts, host)
+		}
+	}
+	// SSH handshakes can take a while, so dial all the hosts at once.
+	sftpConns := make([]*sftpfs.FS, len(sshHosts))
+	dialErrs := make([]error, len(sshHosts))
+	cfg := sftpfs.Config{
+		Port:     *P,
+		Retries:  *retries,
+		Sessions: *sessions,
+
+		MaxPacket:   *sftpMaxPacket,
+		MaxRequests: *sftpRequests,
+
+		KnownHosts:      *knownHosts,
+		HostKeyChecking: hostKeyChecking,
+		IdentityFiles:   identityFiles,
+		Options:         sshOptions,
+
+		ServerAliveInterval: *serverAliveInterval,
+		ConnectTimeout:      *connectTimeout,
+
+		Logger: logger,
+	}
+	var wg sync.WaitGroup
+	for i, host := range sshHosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if *rsh != "" {
+				sftpConns[i], dialErrs[i] = sftpfs.DialCommand(host, rshCommand(host), cfg)
+			} else {
+				sftpConns[i], dialErrs[i] = sftpfs.Dial(host, cfg)
+			}
+		}()
+	}
+	wg.Wait()
+	sftpHosts := make(map[string]*sftpfs.FS)
+	for i, conn := range sftpConns {
+		if conn != nil {
+			defer conn.Close()
+			sftpHosts[sshHosts[i]] = conn
+		}
+	}
+	if err := errors.Join(dialErrs...); err != nil {
+		return &exitError{exitConnect, err}
+	}
+	var srcs []cp.FSPath
+	for _, tgt := range srcTargets {
+		src, closer, err := toFSPath(tgt, sftpHosts, ftpHosts, false)
 		if err != nil {
 			return err
 		}
-		defer fs.Close()
-		sftpHosts[host] = fs
+		if closer != nil {
+			defer closer.Close()
+		}
+		if _, ok := src.FS.(*sftpfs.FS); ok || *glob {
+			srcs = append(srcs, expandGlob(src)...)
+			continue
+		}
+		srcs = append(srcs, src)
 	}
-	srcs := make([]cp.FSPath, len(srcTargets))
-	for i, tgt := range srcTargets {
-		srcs[i] = toFSPath(tgt, sftpHosts)
+	// Check the sources up front, so that a typo gets a clear error
+	// rather than a half-drawn progress bar.
+	var missing []string
+	for _, src := range srcs {
+		if _, err := wfs.Lstat(src.FS, src.Path); errors.Is(err, fs.ErrNotExist) {
+			missing = append(missing, src.String())
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("no such file or directory: %s", strings.Join(missing, ", "))
+	}
+	if *compare && dstTarget == "-" {
+		return usageError("-checksum-only can't be used when copying to stdout")
+	}
+	if *touch && dstTarget == "-" {
+		return usageError("-times-only can't be used when copying to stdout")
+	}
+	// Comparing and setting times only read the target, so an archive is
+	// opened for reading rather than created.
+	dst, dstCloser, err := toFSPath(dstTarget, sftpHosts, ftpHosts, !*compare && !*touch)
+	if err != nil {
+		return err
+	}
+	// A single file copied to a .gz target is compressed, and one copied
+	// from a .gz source to a target file that isn't is decompressed.
+	if len(srcs) == 1 && dstTarget != "-" && !*compare && !*touch {
+		srcGz, dstGz := strings.HasSuffix(srcs[0].Path, ".gz"), strings.HasSuffix(dstTarget, ".gz")
+		gz := false
+		switch {
+		case dstGz && !srcGz:
+			dst, gz = cp.Gzip(dst), true
+		case srcGz && !dstGz && !strings.HasSuffix(dstTarget, "/"):
+			if stat, err := fs.Stat(dst.FS, dst.Path); err != nil || !stat.IsDir() {
+				srcs[0], gz = cp.Gzip(srcs[0]), true
+			}
+		}
+		if gz {
+			for _, f := range []struct {
+				name string
+				set  bool
+			}{{"atomic", *atomic}, {"temp-dir", *tempDir != ""}, {"c", *c}, {"resume", *resume}} {
+				if f.set {
+					return usageError("-%s can't be used when compressing or decompressing", f.name)
+				}
+			}
+		}
+	}
+	// Interrupting the copy cancels it, so that partially copied files are
+	// cleaned up. A second interrupt exits immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+	// When copying to stdout, anything else normally printed there goes
+	// to stderr instead.
+	verboseOut := io.Writer(os.Stdout)
+	if dstTarget == "-" {
+		if *jsonOut {
+			return usageError("-json can't be used when copying to stdout")
+		}
+		verboseOut = os.Stderr
+	}
+	var m *manifest
+	if *manifestFile != "" && !*compare {
+		if m, err = createManifest(*manifestFile); err != nil {
+			return err
+		}
+		opts.Copied = m.record
+	}
+	switch {
+	case *compare:
+		err = compareTrees(ctx, srcs, dst, opts)
+	case *jsonOut:
+		err = copyJSON(ctx, srcs, dst, opts)
+	case *progressEvery > 0 && !*q:
+		err = copyPlain(ctx, srcs, dst, opts, *progressEvery, verboseOut)
+	// The progress bar is drawn on stderr, and is just noise if that's
+	// been redirected to a file.
+	case *q || !term.IsTerminal(int(os.Stderr.Fd())):
+		err = copyQuiet(ctx, srcs, dst, opts, *v, verboseOut)
+	default:
+		err = copyProgressBar(ctx, srcs, dst, opts)
+	}
+	if m != nil {
+		if closeErr := m.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if dstCloser != nil {
+		if closeErr := dstCloser.Close(); err == nil {
+			err = closeErr
+		}
 	}
-	dst := toFSPath(dstTarget, sftpHosts)
+	return err
+}
 
+// copyProgressBar copies srcs to dst while drawing a progress bar on the
+// terminal.
+func copyProgressBar(ctx context.Context, srcs []cp.FSPath, dst cp.FSPath, opts cp.Options) error {
 	bar := progress.New(progress.WithDefaultGradient(), progress.WithoutPercentage())
 	doneCh := make(chan struct{})
-	measurements := new(deque.Deque[measurement])
-	eta := time.Duration(-1)
+	speedometer := newSpeedometer()
 
-	currentProgress := new(progressUpdater)
+	currentProgress := &progressUpdater{verbose: *v}
+	renderer := render.New()
+	// termMu is held while drawing a frame, so that the progress bar can
+	// be cleared away while asking the user something.
+	var termMu sync.Mutex
+	if confirm := opts.ConfirmOverwrite; confirm != nil {
+		opts.ConfirmOverwrite = func(dst string) bool {
+			termMu.Lock()
+			defer termMu.Unlock()
+			renderer.Clear(0)
+			renderer.Flush()
+			return confirm(dst)
+		}
+	}
+	start := time.Now()
 	go func() {
 		defer close(doneCh)
-		cp.Copy(currentProgress, srcs, dst, *f) // Where the magic happens
+		cp.Copy(ctx, currentProgress, srcs, dst, opts) // Where the magic happens
 	}()
 
 	frameTimer := time.NewTicker(time.Second / 30)
@@ -140,7 +957,6 @@ func run() error {
 	etaTimer := time.NewTicker(500 * time.Millisecond)
 	defer etaTimer.Stop()
 	done := false
-	renderer := render.New()
 	for !done {
 		select {
 		case now := <-etaTimer.C:
@@ -148,26 +964,14 @@ func run() error {
 			current := currentProgress.current
 			max := currentProgress.max
 			currentProgress.mu.Unlock()
-
-			for measurements.Len() > 1 && now.Sub(measurements.At(0).t) > 2*time.Minute {
-				measurements.PopFront()
-			}
-			measurements.PushBack(measurement{now, current})
-
-			if max > 0 {
-				first := measurements.At(0)
-				if delta := current - first.i; delta != 0 {
-					deltaT := now.Sub(first.t)
-					eta = time.Duration(float64(max-current) / float64(delta) * float64(deltaT))
-				}
-			}
+			speedometer.measure(now, current, max)
 			continue
 		case <-doneCh:
 			done = true
 		case <-frameTimer.C:
 		}
 
-		width, height, err := term.GetSize(int(os.Stdout.Fd()))
+		width, height, err := term.GetSize(int(os.Stderr.Fd()))
 		if err != nil {
 			width = 80
 		}
@@ -178,10 +982,20 @@ func run() error {
 		maxBytes := currentProgress.max
 		copyingFrom := currentProgress.copyingFrom
 		copyingTo := currentProgress.copyingTo
+		fileSize := currentProgress.fileSize
+		fileCurrent := currentProgress.fileCurrent
+		files := currentProgress.files
+		maxFiles := currentProgress.maxFiles
 		errs := currentProgress.errs
+		copied := currentProgress.copied
+		currentProgress.copied = nil
 		currentProgress.mu.Unlock()
 
+		termMu.Lock()
 		renderer.Clear(width)
+		for _, line := range copied {
+			renderer.Println(line)
+		}
 		copyingFile := ""
 		if copyingFrom != "" {
 			copyingFile = copyingFrom + " -> " + copyingTo
@@ -197,44 +1011,114 @@ func run() error {
 			progress = float64(current) / float64(maxBytes)
 		}
 		etaStr := "..."
-		if eta >= 0 {
+		if eta := speedometer.eta; eta >= 0 {
 			etaStr = eta.Round(time.Second).String()
 		}
+		speedStr := "..."
+		if speed := speedometer.speed; speed >= 0 {
+			speedStr = formatBytes(int64(speed)) + "/s"
+		}
+		bytesStr := formatBytes(current)
+		if maxBytes > 0 {
+			bytesStr += " / " + formatBytes(maxBytes)
+		}
+		bytesStr += fmt.Sprintf(", %d / %d files", files, maxFiles)
+		fileProgress := 0.
+		if fileSize > 0 {
+			fileProgress = float64(fileCurrent) / float64(fileSize)
+		}
 		fmt.Fprintf(renderer, `
   %s
   %s
+  %s
+  %s
   ETA: %s
+  Speed: %s
 
 `,
 			copyingFile,
+			bar.ViewAs(fileProgress),
 			bar.ViewAs(progress),
-			etaStr)
-		for _, e := range errs[max(len(errs)-max(height-5, 0), 0):] {
+			bytesStr,
+			etaStr,
+			speedStr)
+		for _, e := range errs[max(len(errs)-max(height-8, 0), 0):] {
 			fmt.Fprintln(renderer, warningStyle(e.Error()))
 		}
 		renderer.Flush()
+		termMu.Unlock()
+	}
+	renderer.Finish()
+	return currentProgress.finish(time.Since(start))
+}
+
+// finish prints a summary of the copy, which took elapsed, and returns
+// errCopyFailed if any files failed.
+func (pu *progressUpdater) finish(elapsed time.Duration) error {
+	pu.mu.Lock()
+	defer pu.mu.Unlock()
+	r := pu.result
+	switch {
+	case r.DryRun:
+		// Nothing was copied, so there's no speed to speak of.
+		fmt.Fprintf(os.Stderr, "Would copy %s (%s)", plural(r.Files, "file"), formatBytes(r.Bytes))
+	case r.TimesOnly:
+		fmt.Fprintf(os.Stderr, "Set the times of %s in %s", plural(r.Files, "file"), elapsed.Round(time.Millisecond))
+	default:
+		fmt.Fprintf(os.Stderr, "Copied %s (%s) in %s, %s/s",
+			plural(r.Files, "file"),
+			formatBytes(r.Bytes),
+			elapsed.Round(time.Millisecond),
+			formatBytes(int64(float64(r.Bytes)/elapsed.Seconds())))
 	}
-	if len(currentProgress.errs) > 0 {
-		return errors.New("exiting with one or more errors")
+	if r.Skipped > 0 {
+		fmt.Fprintf(os.Stderr, ", %d skipped", r.Skipped)
+	}
+	fmt.Fprintln(os.Stderr)
+	if pu.failed {
+		return errCopyFailed
 	}
 	return nil
 }
 
+// plural returns n followed by noun, with an s unless n is 1.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, `Usage: ccp [OPTION]... SOURCE TARGET
   or:  ccp [OPTION]... SOURCE... TARGET
+  or:  ccp [OPTION]... -files-from FILE [SOURCE]... TARGET
 
-Copy SOURCE to TARGET, or multiple SOURCE(s) to a directory TARGET.
-Uses SFTP for remote file copies.
+Copy SOURCE to TARGET, or multiple SOURCE(s) to a directory TARGET, which
+is created if it doesn't exist. A TARGET ending in / is always treated as a
+directory, even with a single SOURCE.
+If TARGET is -, the single file SOURCE is written to standard output.
+Uses SFTP for remote file copies, or FTP for targets of the form
+ftp://[user[:password]@]host[:port]/[path].
 
 ccp will ask for passwords or passphrases if they are needed
-for authentication.
+for authentication. FTP targets without a user log in anonymously.
 
 The source and target may be specified as a local pathname or a remote
 host with optional path in the form [user@]host:[path]. Local file names
 can be made explicit using absolute or relative pathnames to avoid ccp
-treating file names containing `+"`"+`:' as host specifiers.
+treating file names containing `+"`"+`:' as host specifiers. Wildcards in
+a quoted remote SOURCE, like 'host:*.txt', are expanded on the remote host.
+
+A local SOURCE or TARGET ending in .tar or .zip is treated as an archive:
+files are copied out of a source archive, or into a new target archive.
+A single file copied to a TARGET ending in .gz is compressed with gzip, and
+a SOURCE ending in .gz copied to a TARGET file is decompressed.
+
+Exit status is 0 if all files were copied, 1 if any failed, 2 for a usage
+error, and 3 if a remote host couldn't be connected to or logged in to.
+With -checksum-only, exit status is 1 if there are any differences too.
 
 Options:
 `)
@@ -244,6 +1128,11 @@ Options:
 
 	if err := run(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		status := 1
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			status = exitErr.status
+		}
+		os.Exit(status)
 	}
 }
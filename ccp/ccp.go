@@ -0,0 +1,180 @@
+// Package ccp exposes the copy engine behind the ccp command, so that other
+// programs can copy files between file systems with their own progress
+// reporting and their own file system implementations.
+//
+// # Stability
+//
+// The identifiers in this package follow the module's semantic versioning:
+// within a major version they won't be removed or changed incompatibly. Two
+// exceptions keep the engine able to grow:
+//
+//   - New fields may be added to [Options]. Their zero values keep the
+//     existing behavior, so set fields by name.
+//   - New optional interfaces, like [LinkFS], may be added. A file system
+//     that doesn't implement one keeps working, without the feature.
+//
+// The [Progress] and [FS] interfaces themselves won't gain methods within a
+// major version. Nothing under internal/ is covered by these guarantees.
+package ccp
+
+import (
+	"context"
+	"io"
+
+	"github.com/rhogenson/ccp/internal/cp"
+	"github.com/rhogenson/ccp/internal/wfs"
+	"github.com/rhogenson/ccp/internal/wfs/osfs"
+)
+
+type (
+	// Progress receives status updates and errors from [Copy]. Its
+	// methods are called concurrently.
+	Progress = cp.Progress
+	// A FileCounter is a Progress that's also told how many regular
+	// files there are to copy. Copy checks whether its Progress is one.
+	FileCounter = cp.FileCounter
+	// A Summarizer is a Progress that's also given a [Result] once Copy
+	// finishes. Copy checks whether its Progress is one.
+	Summarizer = cp.Summarizer
+	// A Result summarizes a finished [Copy].
+	Result = cp.Result
+	// An FSPath is a path on a particular file system.
+	FSPath = cp.FSPath
+	// Options control the behavior of [Copy].
+	Options = cp.Options
+	// A Record describes a regular file copied by [Copy], for
+	// Options.Copied.
+	Record = cp.Record
+	// A Backup says how Options.Backup backs up files before they're
+	// overwritten.
+	Backup = cp.Backup
+	// A Chmod is a list of permission changes for Options.Chmod.
+	Chmod = cp.Chmod
+	// A Reflink says whether Copy clones local files rather than copying
+	// their data.
+	Reflink = cp.Reflink
+)
+
+// ParseChmod parses a comma-separated list of mode changes like rsync's
+// --chmod option, such as "D755,F644" or "u+rwX,go-w".
+func ParseChmod(s string) (*Chmod, error) {
+	return cp.ParseChmod(s)
+}
+
+// The kinds of [Backup].
+const (
+	NoBackup       = cp.NoBackup
+	SimpleBackup   = cp.SimpleBackup
+	NumberedBackup = cp.NumberedBackup
+	ExistingBackup = cp.ExistingBackup
+)
+
+// The kinds of [Reflink].
+const (
+	ReflinkAuto   = cp.ReflinkAuto
+	ReflinkAlways = cp.ReflinkAlways
+	ReflinkNever  = cp.ReflinkNever
+)
+
+// The kinds of [ACLType].
+const (
+	AccessACL  = wfs.AccessACL
+	DefaultACL = wfs.DefaultACL
+)
+
+// DefaultJobs is the number of files copied concurrently if [Options.Jobs] is
+// unset.
+const DefaultJobs = cp.DefaultJobs
+
+// DefaultBufferSize is the size of the buffers files are copied through if
+// [Options.BufferSize] is unset.
+const DefaultBufferSize = cp.DefaultBufferSize
+
+// Copy copies srcs into dst, reporting progress and errors to progress. If
+// there is a single source and dst isn't an existing directory, the source is
+// copied to dst itself, unless dst ends in a slash. With several sources, or a
+// dst ending in a slash, dst is created as a directory if it doesn't exist,
+// and it's an error for it to exist as anything else. If ctx is cancelled,
+// Copy stops starting new files and abandons the ones in progress.
+func Copy(ctx context.Context, progress Progress, srcs []FSPath, dst FSPath, opts Options) {
+	cp.Copy(ctx, progress, srcs, dst, opts)
+}
+
+// A Difference is a way the destination given to [Compare] differs from the
+// source.
+type Difference = cp.Difference
+
+// The kinds of [Difference].
+const (
+	Differs = cp.Differs
+	Missing = cp.Missing
+	Extra   = cp.Extra
+)
+
+// Compare compares srcs with the files in dst that [Copy] would copy them to,
+// without writing anything, and calls diff for each file that differs, is
+// missing from dst, or is only in dst. Regular files of the same size are
+// compared by SHA-256 checksum. src is the zero FSPath for an extra file.
+func Compare(ctx context.Context, progress Progress, srcs []FSPath, dst FSPath, opts Options, diff func(d Difference, src, dst FSPath)) {
+	cp.Compare(ctx, progress, srcs, dst, opts, diff)
+}
+
+// Stream returns a destination for [Copy] that writes the contents of a single
+// regular file to w rather than creating any files.
+func Stream(w io.Writer) FSPath {
+	return cp.Stream(w)
+}
+
+// Gzip returns p as a gzip file. Given to [Copy] as its only source, p is
+// decompressed on the way; as the destination, the single regular file copied
+// to it is compressed.
+func Gzip(p FSPath) FSPath {
+	return cp.Gzip(p)
+}
+
+type (
+	// An FS is a writable file system in the spirit of [io/fs.FS].
+	FS = wfs.FS
+	// A ReadLinkFS is a file system with symbolic links.
+	ReadLinkFS = wfs.ReadLinkFS
+	// A LchtimesFS is a file system that can change the times of a
+	// symbolic link without following it.
+	LchtimesFS = wfs.LchtimesFS
+	// A MkdirModeFS is a file system that can create a directory with a
+	// file mode.
+	MkdirModeFS = wfs.MkdirModeFS
+	// An AppendFS is a file system that can open an existing file for
+	// appending, which is needed to resume copies.
+	AppendFS = wfs.AppendFS
+	// A LinkFS is a file system that supports hard links.
+	LinkFS = wfs.LinkFS
+	// A RenameFS is a file system that can move files, which Options.Move
+	// uses to avoid copying them.
+	RenameFS = wfs.RenameFS
+	// A SyncDirFS is a file system that can commit a directory's entries
+	// to stable storage, which Options.Fsync uses.
+	SyncDirFS = wfs.SyncDirFS
+	// A MknodFS is a file system that can create FIFOs, sockets, and
+	// device nodes, which is needed for Options.Specials.
+	MknodFS = wfs.MknodFS
+	// An XattrFS is a file system that supports extended attributes.
+	XattrFS = wfs.XattrFS
+	// An ACLFS is a file system that supports POSIX access control lists,
+	// which Options.ACLs copies.
+	ACLFS = wfs.ACLFS
+	// An ACLType says which of a file's ACLs an ACLFS method is about.
+	ACLType = wfs.ACLType
+)
+
+// Sub returns a view of the directory dir of fsys, in which names are relative
+// to dir. Unlike [io/fs.Sub], dir may be any path that fsys accepts, such as
+// an absolute path.
+func Sub(fsys FS, dir string) FS {
+	return wfs.Sub(fsys, dir)
+}
+
+// Local returns the local file system. Paths on it are interpreted like those
+// passed to package os.
+func Local() FS {
+	return osfs.FS{}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/rhogenson/ccp/internal/cp"
+)
+
+// errDiffer is returned by compareTrees when it finds any differences.
+var errDiffer = errors.New("the sources and target differ")
+
+type diffEvent struct {
+	Event string `json:"event"`         // "differs", "missing", or "extra"
+	Src   string `json:"src,omitempty"` // Omitted for "extra"
+	Dst   string `json:"dst"`
+}
+
+// compareTrees compares srcs with dst for -checksum-only, without copying
+// anything. Each difference is printed to stdout as a line with its kind and
+// the target path, separated by a tab, or as a JSON event with -json. Errors
+// go to stderr, or are also JSON events with -json.
+func compareTrees(ctx context.Context, srcs []cp.FSPath, dst cp.FSPath, opts cp.Options) error {
+	var progress cp.Progress
+	var failed func() bool
+	var report func(d cp.Difference, src, dst cp.FSPath)
+	if *jsonOut {
+		jp := &jsonProgress{enc: json.NewEncoder(os.Stdout)}
+		progress, failed = jp, func() bool { return jp.failed }
+		report = func(d cp.Difference, src, dst cp.FSPath) {
+			event := diffEvent{Event: d.String(), Dst: dst.String()}
+			if d != cp.Extra {
+				event.Src = src.String()
+			}
+			jp.emit(event)
+		}
+	} else {
+		qp := &quietProgress{}
+		progress, failed = qp, func() bool { return qp.failed }
+		report = func(d cp.Difference, src, dst cp.FSPath) {
+			fmt.Printf("%s\t%s\n", d, dst)
+		}
+	}
+	differ := false
+	cp.Compare(ctx, progress, srcs, dst, opts, func(d cp.Difference, src, dst cp.FSPath) {
+		differ = true
+		report(d, src, dst)
+	})
+	if failed() {
+		return errCopyFailed
+	}
+	if differ {
+		return errDiffer
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package cp
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rhogenson/ccp/internal/wfs"
+)
+
+// copyACLs copies the access ACL of src to dst, and the default ACL too if
+// they're directories. A destination that can't store ACLs is only a problem,
+// reported once as a warning, if the source has any.
+func (c *copier) copyACLs(src, dst FSPath, dir bool) error {
+	srcFS, ok := src.FS.(wfs.ACLFS)
+	if !ok {
+		c.aclWarning.Do(func() {
+			c.p.Warning(fmt.Errorf("can't copy ACLs from %s: %w", src, errors.ErrUnsupported))
+		})
+		return nil
+	}
+	types := []wfs.ACLType{wfs.AccessACL}
+	if dir {
+		types = append(types, wfs.DefaultACL)
+	}
+	for _, typ := range types {
+		acl, err := srcFS.GetACL(src.Path, typ)
+		if err != nil {
+			return err
+		}
+		dstFS, ok := dst.FS.(wfs.ACLFS)
+		if !ok {
+			if acl != nil {
+				c.aclWarning.Do(func() {
+					c.p.Warning(fmt.Errorf("can't copy ACLs to %s: %w", dst, errors.ErrUnsupported))
+				})
+			}
+			continue
+		}
+		if err := dstFS.SetACL(dst.Path, typ, acl); err != nil {
+			if errors.Is(err, errors.ErrUnsupported) {
+				c.aclWarning.Do(func() {
+					c.p.Warning(fmt.Errorf("can't copy ACLs: %w", err))
+				})
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package cp
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"syscall"
+
+	"github.com/rhogenson/ccp/internal/wfs"
+)
+
+// copyFile copies the data and attributes of the regular file src, described
+// by info, to dst, atomically if Atomic is set.
+func (c *copier) copyFile(src, dst FSPath, info fs.FileInfo) error {
+	if !c.Atomic || c.DryRun {
+		return c.copyContents(src, dst, c.Force)
+	}
+	return c.copyAtomic(src, dst, info)
+}
+
+// copyAtomic copies src to a temporary file next to dst, or in TempDir, and
+// then renames it over dst, so that dst never holds a partial copy. The
+// temporary file is removed if anything goes wrong.
+func (c *copier) copyAtomic(src, dst FSPath, info fs.FileInfo) error {
+	dir := path.Dir(dst.Path)
+	if c.TempDir != "" {
+		dir = c.TempDir
+	}
+	w, name, err := wfs.CreateTemp(dst.FS, dir, "."+path.Base(dst.Path)+".ccp-", c.mode(info).Perm())
+	if err != nil {
+		return err
+	}
+	tmp := FSPath{dst.FS, name}
+	if err := w.Close(); err != nil {
+		tmp.remove()
+		return err
+	}
+	if err := c.copyContents(src, tmp, c.Force); err != nil {
+		tmp.remove()
+		return err
+	}
+	if err := wfs.Rename(dst.FS, tmp.Path, dst.Path); err != nil {
+		tmp.remove()
+		if c.TempDir != "" && errors.Is(err, syscall.EXDEV) {
+			// dst is in a file system mounted below the
+			// destination, which checkTempDir didn't see.
+			c.tempWarning.Do(func() {
+				c.p.Warning(fmt.Errorf("can't rename files from %s to %s, which is on a different file system; copying them in place", c.TempDir, path.Dir(dst.Path)))
+			})
+			return c.copyContents(src, dst, c.Force)
+		}
+		return err
+	}
+	return nil
+}
+
+// checkTempDir checks that TempDir is a directory, and turns off Atomic, with a
+// warning, if it's on a different device from dstRoot, the destination that
+// sources are copied to or into. Only local file systems report devices.
+func (c *copier) checkTempDir(dstRoot FSPath, dstIsDir bool) error {
+	tmp := FSPath{dstRoot.FS, c.TempDir}
+	stat, err := tmp.stat()
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("temporary directory %q is not a directory", tmp)
+	}
+	dir := dstRoot
+	if !dstIsDir {
+		dir.Path = path.Dir(dir.Path)
+	}
+	dirStat, err := dir.stat()
+	if err != nil {
+		// It's only missing with DryRun, or if the copy is about to
+		// fail anyway.
+		return nil
+	}
+	tmpID, ok := identity(stat)
+	dirID, dirOK := identity(dirStat)
+	if ok && dirOK && tmpID.dev != dirID.dev {
+		c.p.Warning(fmt.Errorf("can't copy atomically, since %s is on a different file system from %s", tmp, dir))
+		c.Atomic = false
+	}
+	return nil
+}
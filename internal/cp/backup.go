@@ -0,0 +1,111 @@
+package cp
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/rhogenson/ccp/internal/wfs"
+)
+
+// A Backup says what to do with an existing destination file before it's
+// overwritten, like cp's --backup option.
+type Backup int
+
+const (
+	// NoBackup overwrites the file.
+	NoBackup Backup = iota
+	// SimpleBackup renames the file by adding Options.BackupSuffix, as in
+	// file~, replacing any older backup.
+	SimpleBackup
+	// NumberedBackup renames the file with the next free number, as in
+	// file.~1~, file.~2~, and so on.
+	NumberedBackup
+	// ExistingBackup makes a numbered backup if the file has any already,
+	// and otherwise a simple one.
+	ExistingBackup
+)
+
+// backup renames the existing destination dst out of the way, if Backup is set.
+func (c *copier) backup(dst FSPath) error {
+	if c.Backup == NoBackup || c.DryRun {
+		return nil
+	}
+	stat, err := dst.lstat()
+	if err != nil || stat.IsDir() {
+		// Nothing to back up.
+		return nil
+	}
+	name := dst.Path + c.backupSuffix()
+	if c.Backup != SimpleBackup {
+		n, err := lastBackup(dst)
+		if err != nil {
+			return err
+		}
+		if n > 0 || c.Backup == NumberedBackup {
+			name = fmt.Sprintf("%s.~%d~", dst.Path, n+1)
+		}
+	}
+	if err := wfs.Rename(dst.FS, dst.Path, name); err != nil {
+		return fmt.Errorf("can't back up %s: %w", dst, err)
+	}
+	return nil
+}
+
+func (c *copier) backupSuffix() string {
+	if c.BackupSuffix == "" {
+		return "~"
+	}
+	return c.BackupSuffix
+}
+
+// lastBackup returns the number of the newest numbered backup of dst, or 0 if
+// there are none.
+func lastBackup(dst FSPath) (int, error) {
+	entries, err := fs.ReadDir(dst.FS, path.Dir(dst.Path))
+	if err != nil {
+		return 0, err
+	}
+	base := path.Base(dst.Path)
+	last := 0
+	for _, e := range entries {
+		if name, n, ok := numberedBackup(e.Name()); ok && name == base && n > last {
+			last = n
+		}
+	}
+	return last, nil
+}
+
+// numberedBackup splits the name of a numbered backup, like file.~2~, into the
+// name of the file backed up and the number.
+func numberedBackup(backup string) (name string, n int, ok bool) {
+	i := strings.LastIndex(backup, ".~")
+	if i < 0 {
+		return "", 0, false
+	}
+	num, ok := strings.CutSuffix(backup[i+2:], "~")
+	n, err := strconv.Atoi(num)
+	if !ok || err != nil {
+		return "", 0, false
+	}
+	return backup[:i], n, true
+}
+
+// isBackup reports whether name could be a backup made with Backup, which
+// Delete leaves alone, as rsync does with --backup. Otherwise the backups made
+// while copying would be deleted as soon as the copy was done.
+func (c *copier) isBackup(name string) bool {
+	switch c.Backup {
+	case NoBackup:
+		return false
+	case NumberedBackup:
+	default:
+		if strings.HasSuffix(name, c.backupSuffix()) {
+			return true
+		}
+	}
+	_, _, ok := numberedBackup(path.Base(name))
+	return ok
+}
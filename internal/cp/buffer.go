@@ -0,0 +1,35 @@
+package cp
+
+import (
+	"io"
+	"sync"
+)
+
+// DefaultBufferSize is the size of the buffers files are copied through if
+// [Options.BufferSize] is unset.
+const DefaultBufferSize = 1024 * 1024
+
+// newBufferPool returns a pool of buffers of the given size. Each file being
+// copied holds one at a time, so with Jobs files copied at once, there are
+// about Jobs buffers in use.
+func newBufferPool(size int) *sync.Pool {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	return &sync.Pool{
+		New: func() any {
+			buf := make([]byte, size)
+			return &buf
+		},
+	}
+}
+
+// copyChunk copies up to len(buf) bytes from in to out through buf, and
+// reports whether it reached the end of in.
+func copyChunk(out io.Writer, in io.Reader, buf []byte) (n int64, eof bool, err error) {
+	// io.CopyBuffer will still use cool stuff like copy_file_range as
+	// long as the underlying types are *os.File, in which case buf isn't
+	// needed.
+	n, err = io.CopyBuffer(out, io.LimitReader(in, int64(len(buf))), buf)
+	return n, err == nil && n < int64(len(buf)), err
+}
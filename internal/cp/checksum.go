@@ -0,0 +1,45 @@
+package cp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+)
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// sha256sum streams the file at p through SHA-256.
+func (p FSPath) sha256sum() ([]byte, error) {
+	f, err := p.open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// verify reads back src and dst and checks that they have the same contents,
+// returning their SHA-256 checksum.
+//
+// The SFTP check-file extension would let the server compute the hash without
+// sending the file back over the network, but github.com/pkg/sftp doesn't
+// expose a way to send it, so remote files are always read in full.
+func verify(src, dst FSPath) ([]byte, error) {
+	srcSum, err := src.sha256sum()
+	if err != nil {
+		return nil, err
+	}
+	dstSum, err := dst.sha256sum()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(srcSum, dstSum) {
+		return nil, errChecksumMismatch
+	}
+	return srcSum, nil
+}
@@ -0,0 +1,169 @@
+package cp
+
+import (
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// A Chmod is a list of permission changes to apply to copied files and
+// directories in place of their source modes, like rsync's --chmod option.
+type Chmod struct {
+	clauses []chmodClause
+}
+
+type chmodClause struct {
+	dirs, files bool
+	// An octal clause replaces the mode outright.
+	octal bool
+	mode  fs.FileMode
+	// Otherwise the clause is symbolic, like chmod(1).
+	who     fs.FileMode // Permission bits of the users affected
+	actions []chmodAction
+}
+
+type chmodAction struct {
+	op    byte // '+', '-', or '='
+	perms string
+}
+
+// ParseChmod parses a comma-separated list of mode changes. Each is either an
+// octal mode like 644 or a symbolic mode like u+rwx or go-w, as accepted by
+// chmod(1). A change prefixed by D applies only to directories, and one
+// prefixed by F only to files; otherwise it applies to both.
+func ParseChmod(s string) (*Chmod, error) {
+	var c Chmod
+	for clause := range strings.SplitSeq(s, ",") {
+		cc, err := parseChmodClause(clause)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mode change %q", clause)
+		}
+		c.clauses = append(c.clauses, cc)
+	}
+	return &c, nil
+}
+
+func parseChmodClause(s string) (chmodClause, error) {
+	cc := chmodClause{dirs: true, files: true}
+	if rest, ok := strings.CutPrefix(s, "D"); ok {
+		cc.files, s = false, rest
+	} else if rest, ok := strings.CutPrefix(s, "F"); ok {
+		cc.dirs, s = false, rest
+	}
+	if s == "" {
+		return cc, strconv.ErrSyntax
+	}
+	if '0' <= s[0] && s[0] <= '7' {
+		mode, err := strconv.ParseUint(s, 8, 12)
+		if err != nil {
+			return cc, err
+		}
+		cc.octal = true
+		cc.mode = fs.FileMode(mode & 0777)
+		if mode&04000 != 0 {
+			cc.mode |= fs.ModeSetuid
+		}
+		if mode&02000 != 0 {
+			cc.mode |= fs.ModeSetgid
+		}
+		if mode&01000 != 0 {
+			cc.mode |= fs.ModeSticky
+		}
+		return cc, nil
+	}
+	i := strings.IndexAny(s, "+-=")
+	if i < 0 {
+		return cc, strconv.ErrSyntax
+	}
+	for _, w := range s[:i] {
+		switch w {
+		case 'u':
+			cc.who |= 0700
+		case 'g':
+			cc.who |= 0070
+		case 'o':
+			cc.who |= 0007
+		case 'a':
+			cc.who |= 0777
+		default:
+			return cc, strconv.ErrSyntax
+		}
+	}
+	if cc.who == 0 {
+		cc.who = 0777
+	}
+	for s = s[i:]; s != ""; {
+		a := chmodAction{op: s[0]}
+		s = s[1:]
+		n := strings.IndexAny(s, "+-=")
+		if n < 0 {
+			n = len(s)
+		}
+		a.perms, s = s[:n], s[n:]
+		if strings.Trim(a.perms, "rwxXst") != "" {
+			return cc, strconv.ErrSyntax
+		}
+		cc.actions = append(cc.actions, a)
+	}
+	return cc, nil
+}
+
+// Apply returns mode with the changes applied, for a directory if isDir is set
+// or otherwise a file. Only the permission, setuid, setgid, and sticky bits of
+// the result are meaningful.
+func (c *Chmod) Apply(mode fs.FileMode, isDir bool) fs.FileMode {
+	for _, cc := range c.clauses {
+		if isDir && !cc.dirs || !isDir && !cc.files {
+			continue
+		}
+		if cc.octal {
+			mode = cc.mode
+			continue
+		}
+		for _, a := range cc.actions {
+			var bits fs.FileMode
+			for _, p := range a.perms {
+				switch p {
+				case 'r':
+					bits |= 0444 & cc.who
+				case 'w':
+					bits |= 0222 & cc.who
+				case 'x':
+					bits |= 0111 & cc.who
+				case 'X':
+					// Execute only for directories and files
+					// that are already executable by someone.
+					if isDir || mode&0111 != 0 {
+						bits |= 0111 & cc.who
+					}
+				case 's':
+					if cc.who&0700 != 0 {
+						bits |= fs.ModeSetuid
+					}
+					if cc.who&0070 != 0 {
+						bits |= fs.ModeSetgid
+					}
+				case 't':
+					bits |= fs.ModeSticky
+				}
+			}
+			switch a.op {
+			case '+':
+				mode |= bits
+			case '-':
+				mode &^= bits
+			case '=':
+				clear := cc.who
+				if cc.who&0700 != 0 {
+					clear |= fs.ModeSetuid
+				}
+				if cc.who&0070 != 0 {
+					clear |= fs.ModeSetgid
+				}
+				mode = mode&^clear | bits
+			}
+		}
+	}
+	return mode
+}
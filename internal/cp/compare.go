@@ -0,0 +1,217 @@
+package cp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// A Difference is a way the destination given to [Compare] differs from the
+// source.
+type Difference int
+
+const (
+	// Differs means that a file is in both places, but its contents or
+	// type differ.
+	Differs Difference = iota + 1
+	// Missing means that a source file isn't in the destination.
+	Missing
+	// Extra means that a file in the destination isn't in the source.
+	Extra
+)
+
+func (d Difference) String() string {
+	switch d {
+	case Differs:
+		return "differs"
+	case Missing:
+		return "missing"
+	case Extra:
+		return "extra"
+	}
+	return fmt.Sprintf("Difference(%d)", int(d))
+}
+
+// Compare walks srcs and the files in dstRoot that [Copy] would copy them to,
+// without writing anything, and calls diff for each file that differs, is
+// missing from dstRoot, or is only in dstRoot. Regular files of the same size
+// are compared by SHA-256 checksum, and symlinks by their targets. The
+// contents of a missing directory aren't reported separately, and src is the
+// zero FSPath for an extra file. Progress and errors are reported as they are
+// by Copy; diff is called from one goroutine at a time.
+func Compare(ctx context.Context, progress Progress, srcs []FSPath, dstRoot FSPath, opts Options, diff func(d Difference, src, dst FSPath)) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if opts.Logger != nil {
+		progress = logProgress{progress, opts.Logger}
+	}
+	if opts.FailFast {
+		progress = failFast{progress, cancel}
+	}
+	// Nothing is written, so the sources are never moved.
+	opts.Move, opts.RemoveSourceFiles = false, false
+	var diffMu sync.Mutex
+	report := func(d Difference, src, dst FSPath) {
+		diffMu.Lock()
+		defer diffMu.Unlock()
+		diff(d, src, dst)
+	}
+
+	dstIsDir := len(srcs) != 1 || strings.HasSuffix(dstRoot.Path, "/") || dstRoot.isDir()
+	if dstIsDir {
+		if err := checkTargetDir(dstRoot); err != nil {
+			progress.Error(err)
+			return
+		}
+	}
+	dstRoot.Path = path.Clean(dstRoot.Path)
+	maxConcurrency := opts.Jobs
+	if maxConcurrency <= 0 {
+		maxConcurrency = DefaultJobs
+	}
+	sem := make(chan struct{}, maxConcurrency)
+	c := newCopier(ctx, progress, opts)
+	c.cancel = cancel
+	// A tree is a source directory that's also a directory in the
+	// destination. seen records the destination of everything walked in
+	// it, so that anything else there is extra.
+	type tree struct {
+		root       FSPath
+		seen       map[string]bool
+		incomplete bool // Whether the source walk hit any errors
+	}
+	var trees []*tree
+	var t *tree
+	entries := make(chan *found, findAhead)
+	go c.find(srcs, dstRoot, dstIsDir, entries)
+	defer func() {
+		for range entries {
+		}
+	}()
+	// skipping is a directory whose contents aren't compared, because it's
+	// missing or different in the destination.
+	var skipping *found
+	for f := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		if skipping != nil && f.root == skipping.root && within(f.path, skipping.path) {
+			progress.Progress(f.weight)
+			continue
+		}
+		srcRoot, dstRoot := f.root.src, f.root.dst
+		src := FSPath{srcRoot.FS, f.path}
+		dst := FSPath{dstRoot.FS, path.Join(dstRoot.Path, strings.TrimPrefix(f.path, srcRoot.Path))}
+		if f.path == srcRoot.Path {
+			t = nil
+		}
+		if f.err != nil {
+			progress.Error(f.err)
+			progress.Progress(f.weight)
+			if t != nil {
+				t.incomplete = true
+			}
+			continue
+		}
+		if t != nil {
+			t.seen[dst.Path] = true
+		}
+		if f.d.Type() == 0 && c.filtered(f.info) {
+			continue
+		}
+		dstInfo, err := dst.lstat()
+		if err != nil || dstInfo.Mode().Type() != f.d.Type() {
+			if errors.Is(err, fs.ErrNotExist) {
+				report(Missing, src, dst)
+			} else if err != nil {
+				progress.Error(err)
+			} else {
+				report(Differs, src, dst)
+			}
+			progress.Progress(f.weight)
+			if f.d.IsDir() {
+				skipping = f
+			}
+			continue
+		}
+		switch f.d.Type() {
+		case 0: // regular file
+			if f.info.Size() != dstInfo.Size() {
+				report(Differs, src, dst)
+				progress.Progress(f.weight)
+				continue
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				continue
+			}
+			go func() {
+				defer func() { <-sem }()
+				progress.FileStart(src.String(), dst.String(), f.info.Size())
+				_, err := verify(src, dst)
+				if errors.Is(err, errChecksumMismatch) {
+					report(Differs, src, dst)
+					err = nil
+				}
+				progress.Progress(f.weight)
+				c.fileDone(src, dst, err)
+			}()
+		case fs.ModeDir:
+			if f.path == srcRoot.Path {
+				t = &tree{root: dst, seen: map[string]bool{dst.Path: true}}
+				trees = append(trees, t)
+			}
+			progress.Progress(f.weight)
+		case fs.ModeSymlink:
+			srcTarget, err := src.readLink()
+			if err != nil {
+				progress.Error(err)
+			} else if dstTarget, err := dst.readLink(); err != nil {
+				progress.Error(err)
+			} else if srcTarget != dstTarget {
+				report(Differs, src, dst)
+			}
+			progress.Progress(f.weight)
+		default:
+			// Special files of the same type have nothing else
+			// to compare.
+			progress.Progress(f.weight)
+		}
+	}
+	// Wait for all jobs to complete.
+	for range maxConcurrency {
+		sem <- struct{}{}
+	}
+	for _, t := range trees {
+		if t.incomplete || ctx.Err() != nil {
+			progress.Warning(fmt.Errorf("not looking for extra files in %s because of errors reading the source", t.root))
+			continue
+		}
+		c.findExtra(t.root, t.seen, report)
+	}
+}
+
+// findExtra reports everything in the tree rooted at root whose path isn't in
+// seen as [Extra]. Like [copier.deleteExtraneous], it doesn't look inside an
+// extra directory.
+func (c *copier) findExtra(root FSPath, seen map[string]bool, report func(d Difference, src, dst FSPath)) {
+	fs.WalkDir(root.FS, root.Path, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			c.p.Error(err)
+			return nil
+		}
+		if seen[name] {
+			return nil
+		}
+		report(Extra, FSPath{}, FSPath{root.FS, name})
+		if d.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	})
+}
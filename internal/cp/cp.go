@@ -3,13 +3,18 @@
 package cp
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"log/slog"
 	"path"
 	"slices"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/rhogenson/ccp/internal/wfs"
 	"github.com/rhogenson/ccp/internal/wfs/sftpfs"
@@ -18,17 +23,37 @@ import (
 // Progress is used to asynchronously report status updates and errors to the
 // main program.
 type Progress interface {
-	// Max sets the total number of bytes to be copied. It's expected that
-	// this will only be called once in the program lifetime.
+	// Max sets the total number of bytes to be copied. Copy calls it
+	// several times as it finds files to copy, each time with a larger
+	// total; the last call, before Copy returns, has the final total.
 	Max(int64)
 	// Progress reports that n additional bytes have been copied.
 	Progress(n int64)
-	// FileStart reports that src is currently being copied to dst. Only
-	// called for regular files, not directories or symlinks. cp also
-	// rate-limits calls to FileStart, so not all files will be reported.
-	FileStart(src, dst string)
+	// FileStart reports that src, which is size bytes long, is currently
+	// being copied to dst. Only called for regular files, not directories
+	// or symlinks. cp also rate-limits calls to FileStart, so not all
+	// files will be reported.
+	FileStart(src, dst string, size int64)
+	// FileDone reports that copying src to dst has finished. err is nil
+	// if the file was copied (or deliberately skipped) successfully, and
+	// otherwise its message names both src and dst. Like FileStart, it's
+	// only called for regular files.
+	FileDone(src, dst string, err error)
 	// Error reports an error encountered.
 	Error(error)
+	// Warning reports a problem that doesn't prevent the copy from
+	// succeeding, such as a file attribute that couldn't be preserved.
+	Warning(error)
+}
+
+// A FileCounter is a [Progress] that's also told how many regular files there
+// are to copy, so that it can show how many of them are done. Copy checks
+// whether the Progress it's given is a FileCounter.
+type FileCounter interface {
+	Progress
+	// MaxFiles sets the total number of regular files to be copied. Like
+	// Max, it's called several times with a growing total.
+	MaxFiles(n int)
 }
 
 // An FSPath is an abstraction over a file path that can point to multiple
@@ -40,8 +65,11 @@ type FSPath struct {
 }
 
 func (p FSPath) String() string {
-	if fsys, ok := p.FS.(*sftpfs.FS); ok {
-		return fsys.User + "@" + fsys.Host + ":" + p.Path
+	switch fsys := p.FS.(type) {
+	case *sftpfs.FS:
+		return fsys.Target(p.Path)
+	case gzipFS:
+		return FSPath{fsys.FS, p.Path}.String()
 	}
 	return p.Path
 }
@@ -51,10 +79,6 @@ func (p FSPath) String() string {
 //
 //  src.FS.Open(dst.Path)
 
-func (p FSPath) walkDir(fn fs.WalkDirFunc) error {
-	return fs.WalkDir(p.FS, p.Path, fn)
-}
-
 func (p FSPath) stat() (fs.FileInfo, error) {
 	return fs.Stat(p.FS, p.Path)
 }
@@ -63,6 +87,10 @@ func (p FSPath) lstat() (fs.FileInfo, error) {
 	return wfs.Lstat(p.FS, p.Path)
 }
 
+func (p FSPath) remove() error {
+	return p.FS.Remove(p.Path)
+}
+
 func (p FSPath) removeAll() error {
 	return wfs.RemoveAll(p.FS, p.Path)
 }
@@ -87,37 +115,50 @@ func (p FSPath) mkdir() error {
 	return p.FS.Mkdir(p.Path)
 }
 
+func (p FSPath) mkdirAll() error {
+	return wfs.MkdirAll(p.FS, p.Path)
+}
+
 func (p FSPath) mkdirMode(mode fs.FileMode) error {
 	return wfs.MkdirMode(p.FS, p.Path, mode)
 }
 
+func (p FSPath) linkTo(target FSPath) error {
+	return wfs.Link(p.FS, target.Path, p.Path)
+}
+
+func (p FSPath) mknod(mode fs.FileMode, dev uint64) error {
+	return wfs.Mknod(p.FS, p.Path, mode, dev)
+}
+
+// modeBits returns the permission bits of m along with its setuid, setgid, and
+// sticky bits: everything that Chmod sets.
+func modeBits(m fs.FileMode) fs.FileMode {
+	return m & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+}
+
 func (p FSPath) chmod(mode fs.FileMode) error {
 	return p.FS.Chmod(p.Path, mode)
 }
 
-func size(srcs []FSPath) int64 {
-	var n int64 = 0
-	for _, src := range srcs {
-		src.walkDir(func(_ string, d fs.DirEntry, err error) error {
-			if err != nil {
-				return nil
-			}
-			switch d.Type() {
-			case 0: // regular file
-				stat, err := d.Info()
-				if err != nil {
-					return nil
-				}
-				// The "+ 1" is a fudge factor to make sure that
-				// the total number of bytes won't be zero.
-				n += stat.Size() + 1
-			case fs.ModeSymlink, fs.ModeDir:
-				n++
-			}
-			return nil
-		})
-	}
-	return n
+func (p FSPath) chtimes(atime, mtime time.Time) error {
+	return p.FS.Chtimes(p.Path, atime, mtime)
+}
+
+func (p FSPath) lchtimes(atime, mtime time.Time) error {
+	return wfs.Lchtimes(p.FS, p.Path, atime, mtime)
+}
+
+func (p FSPath) chown(uid, gid int) error {
+	return p.FS.Chown(p.Path, uid, gid)
+}
+
+// fileWeight returns how much the regular file described by info counts
+// toward the total given to Max.
+func fileWeight(info fs.FileInfo) int64 {
+	// The "+ 1" is a fudge factor to make sure that the total number of
+	// bytes won't be zero.
+	return info.Size() + 1
 }
 
 func (p FSPath) exists() bool {
@@ -125,13 +166,293 @@ func (p FSPath) exists() bool {
 	return !errors.Is(err, fs.ErrNotExist)
 }
 
+func (p FSPath) isDir() bool {
+	stat, err := p.stat()
+	return err == nil && stat.IsDir()
+}
+
+// within reports whether the clean path name is inside the directory dir, also
+// clean. Relative paths are assumed to be relative to the same directory.
+func within(name, dir string) bool {
+	switch dir {
+	case ".":
+		return !path.IsAbs(name) && name != ".." && !strings.HasPrefix(name, "../")
+	case "/":
+		return path.IsAbs(name) && name != "/"
+	}
+	return strings.HasPrefix(name, dir+"/")
+}
+
+// filtered reports whether the regular file described by info is left out of
+// the copy by the NewerThan, MinSize, or MaxSize options.
+func (o *Options) filtered(info fs.FileInfo) bool {
+	return !o.NewerThan.IsZero() && !info.ModTime().After(o.NewerThan) ||
+		o.MinSize > 0 && info.Size() < o.MinSize ||
+		o.MaxSize > 0 && info.Size() > o.MaxSize
+}
+
+// removesSources reports whether sources are removed after they're copied.
+func (o *Options) removesSources() bool {
+	return o.Move || o.RemoveSourceFiles
+}
+
+// filtering reports whether any files might be left out by filtered.
+func (o *Options) filtering() bool {
+	return !o.NewerThan.IsZero() || o.MinSize > 0 || o.MaxSize > 0
+}
+
+// Options control the behavior of [Copy].
+type Options struct {
+	// Force removes an existing destination file that cannot be opened
+	// and tries again.
+	Force bool
+	// NoClobber skips any destination that already exists, whether it's
+	// a file, a symlink, or a special file, leaving it untouched whatever
+	// its size or time. An existing directory is copied into, but not
+	// changed itself. NoClobber takes precedence over Force, Update, and
+	// Backup.
+	NoClobber bool
+	// ConfirmOverwrite, if set, is called before a regular file is copied
+	// over an existing destination dst, and the file is skipped if it
+	// returns false. Calls are never concurrent, so it can safely prompt
+	// the user. NoClobber takes precedence, so that nothing is asked.
+	ConfirmOverwrite func(dst string) bool
+	// Backup, if set, renames an existing destination file out of the way
+	// before a regular file is copied over it. NoClobber takes
+	// precedence, so that nothing is overwritten at all.
+	Backup Backup
+	// BackupSuffix is added to the names of simple backups. If it's
+	// empty, "~" is used.
+	BackupSuffix string
+	// Chmod, if set, changes the modes given to copied files and
+	// directories, which otherwise get the modes of their sources.
+	Chmod *Chmod
+	// PreserveTimes copies the access and modification times of each
+	// source file to the destination. A directory's times are set once
+	// everything in it has been copied, including a directory that
+	// already existed.
+	PreserveTimes bool
+	// PreserveOwner copies the uid and gid of each source file to the
+	// destination. If the process isn't permitted to change ownership, a
+	// single warning is reported and the copy otherwise carries on.
+	PreserveOwner bool
+	// PreserveLinks recreates hard links between source files as hard
+	// links between their copies, rather than copying the data again. If
+	// the destination doesn't support hard links, the files are copied.
+	PreserveLinks bool
+	// Sparse skips over holes in local source files, leaving holes in the
+	// destination instead of writing out runs of zeros.
+	Sparse bool
+	// Reflink says whether to clone local files instead of copying their
+	// data.
+	Reflink Reflink
+	// SafeLinks rewrites absolute symlink targets inside the source tree to
+	// be relative, so that they still point into the copy, and warns about
+	// symlinks that point outside the tree or to nothing at all.
+	SafeLinks bool
+	// Specials recreates FIFOs, sockets, and device nodes at the
+	// destination. Otherwise they're skipped, with a single warning.
+	Specials bool
+	// Xattrs copies the extended attributes of regular files and
+	// directories. If either file system doesn't support extended
+	// attributes, a single warning is reported.
+	Xattrs bool
+	// ACLs copies the POSIX access control lists of regular files and
+	// directories, and the default ACLs of directories, after their modes.
+	// If the source has ACLs that the destination can't store, a single
+	// warning is reported.
+	ACLs bool
+	// Update skips regular files whose destination has the same size as
+	// the source and a modification time that is not older.
+	Update bool
+	// AlwaysCopy copies regular files whose destination already has the
+	// same size and modification time as the source. Otherwise, with
+	// PreserveTimes, such a destination is taken to be an earlier copy and
+	// skipped without reading either file, unless the source is to be
+	// removed or the copy is to be given attributes, like Chmod or
+	// PreserveOwner, that the earlier copy might lack.
+	AlwaysCopy bool
+	// NewerThan, if set, skips regular files modified at or before it.
+	// Directories are still created, whatever their times.
+	NewerThan time.Time
+	// MinSize and MaxSize, if positive, skip regular files smaller or
+	// larger than them.
+	MinSize, MaxSize int64
+	// Verify reads back each regular file after it's copied and compares
+	// its SHA-256 checksum against the source. If Force is also set, a
+	// file that fails verification is copied again once.
+	Verify bool
+	// Resume continues copying a regular file whose destination holds a
+	// prefix of the source, such as after an interrupted copy, rather than
+	// starting over. The prefix is verified before it's reused.
+	Resume bool
+	// Jobs is the maximum number of files copied concurrently. If Jobs is
+	// 1, files are copied one at a time in the order they're walked. If
+	// Jobs is 0, DefaultJobs is used.
+	Jobs int
+	// BufferSize is the size in bytes of the buffer each file is copied
+	// through. Buffers are reused from file to file, so copying takes
+	// about Jobs*BufferSize bytes of them. If BufferSize is 0,
+	// DefaultBufferSize is used.
+	BufferSize int
+	// FollowRoots copies the targets of any symlinks in the sources
+	// passed to Copy, rather than the links themselves. Symlinks found
+	// inside source directories are still copied as links.
+	FollowRoots bool
+	// FollowLinks copies the targets of every symlink, both in the
+	// sources and inside source directories. Symlink loops are reported
+	// as errors.
+	FollowLinks bool
+	// Parents creates any missing parent directories of the destination,
+	// like install -D, with default permissions.
+	Parents bool
+	// TrailingSlash copies the contents of a source directory whose path
+	// ends in a slash, rather than the directory itself, like rsync. So
+	// with an existing directory dst, src/ is copied to dst but src to
+	// dst/src.
+	TrailingSlash bool
+	// Delete removes files in the destination that aren't in the source,
+	// making it a mirror. It only applies when copying a single source
+	// directory, and nothing is deleted if there were any errors reading
+	// the source or if the source is empty. With Backup, files named like
+	// backups are kept.
+	Delete bool
+	// Move moves the sources to the destination instead of copying them.
+	// A source on the same file system as the destination is renamed
+	// where that's equivalent to copying it. Otherwise it's copied, and
+	// each file is removed once it has been copied successfully. Files
+	// that are skipped or fail to copy are left in place, along with the
+	// directories containing them.
+	Move bool
+	// RemoveSourceFiles removes each source file once it has been copied
+	// successfully, and verified if Verify is set, and then each source
+	// directory once it's empty. It's like Move without renaming.
+	RemoveSourceFiles bool
+	// DryRun walks the source and reports progress as usual, but doesn't
+	// modify the destination. Source files are opened to surface any
+	// errors, but not read.
+	DryRun bool
+	// TimesOnly copies nothing, but sets the access and modification times
+	// of each existing destination whose modification time differs from
+	// its source's, such as to fix up a tree copied by another tool.
+	TimesOnly bool
+	// FailFast stops the copy at the first error. No new files are
+	// started, and files already being copied are abandoned.
+	FailFast bool
+	// Atomic copies each regular file to a temporary file in the
+	// destination directory, syncs it and sets its attributes, and then
+	// renames it into place, so that the destination never holds a
+	// partial copy. If the destination file system can't rename files,
+	// files are copied in place, with a warning. Resume has no effect on
+	// files copied atomically.
+	Atomic bool
+	// TempDir, if set, is the directory on the destination file system
+	// that Atomic writes temporary files to, instead of each file's own
+	// directory, such as when the destination directories aren't
+	// writable. A rename can't move files between file systems, so where
+	// TempDir is found to be on a different one from the destination,
+	// files are copied in place, with a warning.
+	TempDir string
+	// Fsync commits each copied file to stable storage before it's
+	// reported done, and at the end of the copy, commits the entries of
+	// each destination directory, so that the copy survives a crash. It's
+	// skipped, with a warning, where the destination doesn't support it.
+	Fsync bool
+	// Copied, if set, is called with a [Record] of each regular file
+	// after it's copied, but not for files that are skipped. Calls may be
+	// concurrent.
+	Copied func(Record)
+	// Logger, if set, receives debugging logs: each regular file as it's
+	// started and finished at debug level, and errors and warnings as
+	// they're reported to the Progress. They're separate from the
+	// Progress, meant for diagnosing a copy after the fact.
+	Logger *slog.Logger
+}
+
+// A Record describes a regular file copied by [Copy], for Options.Copied.
+type Record struct {
+	Src, Dst FSPath
+	Size     int64
+	Mode     fs.FileMode // The mode given to the copy
+	// SHA256 is the checksum of the file's contents if Options.Verify is
+	// set, and otherwise nil.
+	SHA256 []byte
+}
+
+// record reports that the regular file src, described by info, has been
+// copied to dst, counting it for the Result and passing it to Copied if set.
+func (c *copier) record(src, dst FSPath, info fs.FileInfo) {
+	c.stats.copied(info.Size())
+	if c.Copied == nil || c.DryRun {
+		return
+	}
+	c.Copied(Record{src, dst, info.Size(), c.mode(info), c.sum})
+}
+
+// DefaultJobs is the number of files copied concurrently if [Options.Jobs] is
+// unset.
+const DefaultJobs = 10
+
+func newCopier(ctx context.Context, progress Progress, opts Options) *copier {
+	return &copier{
+		Options:      opts,
+		ctx:          ctx,
+		p:            progress,
+		chownWarning: new(sync.Once),
+		xattrWarning: new(sync.Once),
+		aclWarning:   new(sync.Once),
+		skipWarning:  new(sync.Once),
+		syncWarning:  new(sync.Once),
+		tempWarning:  new(sync.Once),
+		fullWarning:  new(sync.Once),
+		confirmMu:    new(sync.Mutex),
+		links:        make(map[fileID]*linkedFile),
+		buffers:      newBufferPool(opts.BufferSize),
+		stats:        new(stats),
+	}
+}
+
 type copier struct {
-	p     Progress
-	force bool
+	Options
+	ctx context.Context
+	p   Progress
+
+	// Shared by every copier made with item.
+	chownWarning *sync.Once
+	xattrWarning *sync.Once
+	aclWarning   *sync.Once
+	skipWarning  *sync.Once
+	syncWarning  *sync.Once
+	tempWarning  *sync.Once
+	fullWarning  *sync.Once
+	confirmMu    *sync.Mutex // Serializes calls to ConfirmOverwrite
+	buffers      *sync.Pool  // Of *[]byte, for copying file data
+	stats        *stats
+
+	// links maps each source file with multiple hard links to where it
+	// was first copied, if PreserveLinks is set. It's only used by the
+	// walk, so it isn't locked.
+	links map[fileID]*linkedFile
+
+	// cancel stops the copy, such as when the destination is full.
+	cancel context.CancelFunc
+
+	// sum is the SHA-256 checksum of the file copied by a copier made with
+	// item, if Verify is set.
+	sum []byte
+}
+
+// mode returns the mode to give the copy of the file described by info.
+func (c *copier) mode(info fs.FileInfo) fs.FileMode {
+	mode := modeBits(info.Mode())
+	if c.Chmod != nil {
+		mode = modeBits(c.Chmod.Apply(mode, info.IsDir()))
+	}
+	return mode
 }
 
 func (c *copier) openWithRetry(path FSPath, fn func() error) error {
-	if err := fn(); err == nil || !c.force || !path.exists() {
+	if err := fn(); err == nil || !c.Force || !path.exists() {
 		return err
 	}
 	if err := path.removeAll(); err != nil {
@@ -140,128 +461,852 @@ func (c *copier) openWithRetry(path FSPath, fn func() error) error {
 	return fn()
 }
 
-func (c *copier) copyRegularFile(src, dst FSPath) error {
-	c.p.FileStart(src.String(), dst.String())
+// chown gives dst the same owner as the file described by src.
+func (c *copier) chown(src fs.FileInfo, dst FSPath) error {
+	uid, gid, ok := owner(src)
+	if !ok {
+		return nil
+	}
+	err := dst.chown(uid, gid)
+	switch {
+	case err == nil, errors.Is(err, errors.ErrUnsupported):
+		return nil
+	case errors.Is(err, fs.ErrPermission):
+		c.chownWarning.Do(func() {
+			c.p.Warning(fmt.Errorf("can't preserve ownership: %w", err))
+		})
+		return nil
+	}
+	return err
+}
 
-	in, err := src.open()
-	if err != nil {
+// sync checks the error from syncing dst. If syncing isn't supported, that's
+// only an error with Fsync, and then only a warning.
+func (c *copier) sync(err error, dst FSPath) error {
+	if !errors.Is(err, errors.ErrUnsupported) {
 		return err
 	}
-	defer in.Close()
-	stat, err := in.Stat()
+	if c.Fsync {
+		c.syncWarning.Do(func() {
+			c.p.Warning(fmt.Errorf("can't sync %s: %w", dst, errors.ErrUnsupported))
+		})
+	}
+	return nil
+}
+
+// copyXattrs copies the extended attributes of src to dst.
+func (c *copier) copyXattrs(src, dst FSPath) error {
+	srcFS, srcOK := src.FS.(wfs.XattrFS)
+	dstFS, dstOK := dst.FS.(wfs.XattrFS)
+	if !srcOK || !dstOK {
+		c.xattrWarning.Do(func() {
+			c.p.Warning(fmt.Errorf("can't copy extended attributes from %s to %s: %w", src, dst, errors.ErrUnsupported))
+		})
+		return nil
+	}
+	attrs, err := srcFS.ListXattr(src.Path)
 	if err != nil {
 		return err
 	}
-	var out io.WriteCloser
-	if err := c.openWithRetry(dst, func() error {
-		var err error
-		out, err = dst.create(stat.Mode().Perm())
+	for _, attr := range attrs {
+		data, err := srcFS.GetXattr(src.Path, attr)
+		if err != nil {
+			return err
+		}
+		if err := dstFS.SetXattr(dst.Path, attr, data); err != nil {
+			if errors.Is(err, errors.ErrUnsupported) {
+				c.xattrWarning.Do(func() {
+					c.p.Warning(fmt.Errorf("can't copy extended attributes: %w", err))
+				})
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// copyRegularFile copies the regular file src, described by info, to dst.
+func (c *copier) copyRegularFile(src, dst FSPath, info fs.FileInfo) error {
+	c.p.FileStart(src.String(), dst.String(), info.Size())
+	if c.skip(info, dst) || !c.confirmOverwrite(dst) {
+		c.stats.skipped.Add(1)
+		return nil
+	}
+	if err := c.backup(dst); err != nil {
 		return err
-	}); err != nil {
+	}
+	if err := c.copyFile(src, dst, info); err != nil {
 		return err
 	}
+	c.record(src, dst, info)
+	return c.removeSource(src)
+}
+
+// removeSource removes src once it has been copied, if Move or
+// RemoveSourceFiles is set.
+func (c *copier) removeSource(src FSPath) error {
+	if !c.removesSources() || c.DryRun {
+		return nil
+	}
+	return src.remove()
+}
+
+// rename moves src to dst with a single rename, if Move is set, and reports
+// whether it did, along with the progress it counts for and whether src is a
+// regular file. That's the weight of src itself, even if it's a directory,
+// since its contents aren't walked. It only tries where that's equivalent to
+// copying src and removing it.
+func (c *copier) rename(src, dst FSPath) (n int64, regular, ok bool) {
+	if !c.Move || c.DryRun || src.FS != dst.FS ||
+		c.Chmod != nil || c.FollowRoots || c.FollowLinks || c.filtering() {
+		return 0, false, false
+	}
+	if stat, err := dst.lstat(); err == nil {
+		// Copying would merge into an existing directory, and
+		// might not replace an existing file, or would back it up
+		// first.
+		if stat.IsDir() || c.NoClobber || c.Update || c.ConfirmOverwrite != nil ||
+			c.Backup != NoBackup {
+			return 0, false, false
+		}
+	}
+	info, err := src.lstat()
+	if err != nil {
+		return 0, false, false
+	}
+	if wfs.Rename(src.FS, src.Path, dst.Path) != nil {
+		return 0, false, false
+	}
+	if !info.Mode().IsRegular() {
+		return 1, false, true
+	}
+	c.record(src, dst, info)
+	c.p.FileDone(src.String(), dst.String(), nil)
+	return fileWeight(info), true, true
+}
+
+// skip reports whether copying the regular file described by src to dst can be
+// skipped entirely.
+func (c *copier) skip(src fs.FileInfo, dst FSPath) bool {
+	if c.NoClobber {
+		return dst.exists()
+	}
+	// Skipping would also skip setting the attributes a re-run may have
+	// been asked to apply.
+	quick := c.PreserveTimes && !c.AlwaysCopy && !c.removesSources() &&
+		c.Chmod == nil && !c.PreserveOwner && !c.Xattrs && !c.ACLs
+	if !c.Update && !quick {
+		return false
+	}
+	stat, err := dst.stat()
+	if err != nil || !stat.Mode().IsRegular() || stat.Size() != src.Size() {
+		return false
+	}
+	if c.Update && !stat.ModTime().Before(src.ModTime()) {
+		return true
+	}
+	return quick && sameTime(src.ModTime(), stat.ModTime())
+}
+
+// sameTime reports whether dst is the modification time src would have been
+// copied as, allowing for file systems like SFTP that only keep whole seconds.
+func sameTime(src, dst time.Time) bool {
+	return dst.Equal(src) || dst.Nanosecond() == 0 && dst.Equal(src.Truncate(time.Second))
+}
+
+// confirmOverwrite reports whether the regular file dst may be overwritten.
+func (c *copier) confirmOverwrite(dst FSPath) bool {
+	if c.ConfirmOverwrite == nil || c.DryRun || !dst.exists() {
+		return true
+	}
+	c.confirmMu.Lock()
+	defer c.confirmMu.Unlock()
+	return c.ConfirmOverwrite(dst.String())
+}
+
+// copyData copies everything from in to out, reporting progress as it goes.
+func (c *copier) copyData(out io.Writer, in io.Reader) error {
+	buf := c.buffers.Get().(*[]byte)
+	defer c.buffers.Put(buf)
 	for {
-		// io.CopyN will use cool stuff like copy_file_range as long as
-		// the underlying types are *os.File
-		n, err := io.CopyN(out, in, 1024*1024)
+		if err := c.ctx.Err(); err != nil {
+			return err
+		}
+		n, eof, err := copyChunk(out, in, *buf)
 		if n > 0 {
 			c.p.Progress(n)
 		}
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
+		if eof || err != nil {
+			return err
+		}
+	}
+}
+
+// abandon removes a partially written destination file, so that it isn't
+// mistaken for a complete copy. With Resume, the partial file is kept so that
+// the copy can be continued later.
+func (c *copier) abandon(dst FSPath) {
+	if !c.Resume {
+		dst.removeAll()
+	}
+}
+
+// copyContents copies the data of the regular file src to dst. If verification
+// fails and retry is set, copyContents copies the file a second time.
+func (c *copier) copyContents(src, dst FSPath, retry bool) error {
+	in, err := src.open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if c.DryRun {
+		return nil
+	}
+	var out io.WriteCloser
+	if c.Resume {
+		if out, err = c.openResume(in, stat, dst); err != nil {
+			return err
+		}
+	}
+	// A resumed file already holds part of the data, so it can't be
+	// cloned or written sparsely.
+	fresh := out == nil
+	if out == nil {
+		if err := c.openWithRetry(dst, func() error {
+			var err error
+			out, err = dst.create(c.mode(stat).Perm())
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	copied := false
+	if fresh {
+		copied, err = c.reflink(out, in, src, dst, stat.Size())
+	}
+	if fresh && c.Sparse && !copied && err == nil {
+		copied, err = c.copySparse(out, in, stat.Size())
+	}
+	if !copied && err == nil {
+		var w io.Writer = out
+		if c.Reflink == ReflinkNever {
+			w = onlyWriter{out}
+		}
+		err = c.copyData(w, in)
+	}
+	if err != nil {
+		out.Close()
+		c.abandon(dst)
+		return err
+	}
+	if c.Atomic || c.Fsync {
+		// With Atomic, the rename could otherwise reach the disk
+		// before the data.
+		if err := c.sync(wfs.Sync(out), dst); err != nil {
 			out.Close()
+			c.abandon(dst)
 			return err
 		}
 	}
 	if err := out.Close(); err != nil {
+		c.abandon(dst)
 		return err
 	}
-	c.p.Progress(1)
+	if c.Verify {
+		if c.sum, err = verify(src, dst); err != nil {
+			if !retry || !errors.Is(err, errChecksumMismatch) {
+				return err
+			}
+			// Take back the progress from the failed attempt.
+			c.p.Progress(-stat.Size())
+			return c.copyContents(src, dst, false)
+		}
+	}
+	return c.copyAttrs(src, dst, stat)
+}
+
+// copyAttrs gives dst, a regular file just written, the attributes of src,
+// which is described by stat.
+func (c *copier) copyAttrs(src, dst FSPath, stat fs.FileInfo) error {
+	if c.PreserveOwner {
+		if err := c.chown(stat, dst); err != nil {
+			return err
+		}
+	}
+	// Creating the file drops the setuid, setgid, and sticky bits, and
+	// writing to it or changing its owner may clear them again, so
+	// they're set last. An existing file keeps its old mode when it's
+	// overwritten, which Chmod overrides too.
+	if mode := c.mode(stat); c.Chmod != nil || mode != mode.Perm() {
+		if err := dst.chmod(mode); err != nil {
+			return err
+		}
+	}
+	if c.Xattrs {
+		if err := c.copyXattrs(src, dst); err != nil {
+			return err
+		}
+	}
+	if c.ACLs {
+		if err := c.copyACLs(src, dst, false); err != nil {
+			return err
+		}
+	}
+	if c.PreserveTimes {
+		if err := dst.chtimes(atime(stat), stat.ModTime()); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (c *copier) copySymlink(src FSPath, dst FSPath) error {
+// copySymlink copies the symlink src, found in the source tree root, to dst.
+func (c *copier) copySymlink(src FSPath, dst FSPath, root string) error {
+	if c.NoClobber && dst.exists() {
+		return nil
+	}
 	target, err := src.readLink()
 	if err != nil {
 		return err
 	}
+	if c.SafeLinks {
+		target = c.safeTarget(src, root, target)
+	}
+	if c.DryRun {
+		return nil
+	}
 	if err := c.openWithRetry(dst, func() error {
 		return dst.symlinkFrom(target)
 	}); err != nil {
 		return err
 	}
-	c.p.Progress(1)
-	return nil
+	var stat fs.FileInfo
+	if c.PreserveOwner || c.PreserveTimes {
+		if stat, err = src.lstat(); err != nil {
+			return err
+		}
+	}
+	if c.PreserveOwner {
+		if err := c.chown(stat, dst); err != nil {
+			return err
+		}
+	}
+	if c.PreserveTimes {
+		// Not every backend can set the times on a symlink without
+		// following it, in which case the times are left alone.
+		if err := dst.lchtimes(atime(stat), stat.ModTime()); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+	}
+	return c.removeSource(src)
 }
 
-// Copy copies srcs into dstRoot, reporting progress using the [Progress]
-// interface. If force is specified and an existing destination file cannot be
-// opened, Copy will remove it and try again.
-func Copy(progress Progress, srcs []FSPath, dstRoot FSPath, force bool) {
-	go func() {
-		progress.Max(size(srcs))
-	}()
+// safeTarget returns the target to give the copy of the symlink src, which
+// points to target, for SafeLinks.
+func (c *copier) safeTarget(src FSPath, root, target string) string {
+	dir := path.Dir(src.Path)
+	resolved := target
+	if !path.IsAbs(target) {
+		resolved = path.Join(dir, target)
+	}
+	if path.IsAbs(resolved) != path.IsAbs(root) {
+		// There's no telling where a relative root is, so an
+		// absolute target is left as it is.
+		c.p.Warning(fmt.Errorf("symlink %s has an absolute target %s, which may not exist at the destination", src, target))
+		return target
+	}
+	if resolved != root && !within(resolved, root) {
+		c.p.Warning(fmt.Errorf("symlink %s points outside the copied tree, to %s", src, target))
+		return target
+	}
+	if _, err := src.stat(); errors.Is(err, fs.ErrNotExist) {
+		c.p.Warning(fmt.Errorf("symlink %s is dangling: %s doesn't exist", src, target))
+	}
+	if path.IsAbs(target) {
+		return relPath(dir, resolved)
+	}
+	return target
+}
 
-	dstIsDir := true
-	if len(srcs) == 1 {
-		stat, err := dstRoot.stat()
-		dstIsDir = err == nil && stat.IsDir()
+// relPath returns a relative path to the absolute, clean path name from the
+// absolute, clean directory dir.
+func relPath(dir, name string) string {
+	dirParts := strings.Split(strings.TrimPrefix(dir, "/"), "/")
+	nameParts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	if dir == "/" {
+		dirParts = nil
+	}
+	i := 0
+	for i < len(dirParts) && i < len(nameParts) && dirParts[i] == nameParts[i] {
+		i++
 	}
+	rel := slices.Repeat([]string{".."}, len(dirParts)-i)
+	rel = append(rel, nameParts[i:]...)
+	if len(rel) == 0 {
+		return "."
+	}
+	return path.Join(rel...)
+}
 
-	const maxConcurrency = 10
-	// sem acts as a semaphore to limit the number of concurrent file copies
-	sem := make(chan struct{}, maxConcurrency)
-	c := &copier{
-		p:     progress,
-		force: force,
+// copySpecial copies the FIFO, socket, or device node src, if Specials is set.
+func (c *copier) copySpecial(src, dst FSPath, d fs.DirEntry) error {
+	if !c.Specials {
+		c.skipWarning.Do(func() {
+			c.p.Warning(fmt.Errorf("skipping special files such as %s; use -specials to copy them", src))
+		})
+		return nil
 	}
-	type roDir struct {
-		path FSPath
-		mode fs.FileMode
+	if c.NoClobber && dst.exists() {
+		return nil
 	}
-	var roDirs []roDir
-	dstRoot.Path = path.Clean(dstRoot.Path)
+	stat, err := d.Info()
+	if err != nil {
+		return err
+	}
+	dev, ok := rdev(stat)
+	if !ok && stat.Mode()&fs.ModeDevice != 0 {
+		// Creating it would make a device with the wrong number.
+		return fmt.Errorf("%s: can't get the device number: %w", src, errors.ErrUnsupported)
+	}
+	if c.DryRun {
+		return nil
+	}
+	if err := c.openWithRetry(dst, func() error {
+		return dst.mknod(stat.Mode().Type()|c.mode(stat).Perm(), dev)
+	}); err != nil {
+		return err
+	}
+	// The mode given to mknod is subject to the umask.
+	if err := dst.chmod(c.mode(stat)); err != nil {
+		return err
+	}
+	if c.PreserveOwner {
+		if err := c.chown(stat, dst); err != nil {
+			return err
+		}
+	}
+	if c.PreserveTimes {
+		if err := dst.chtimes(atime(stat), stat.ModTime()); err != nil {
+			return err
+		}
+	}
+	return c.removeSource(src)
+}
+
+// A fileError is an error copying a regular file, as reported to FileDone. It
+// names both the source and the destination, whichever one it came from.
+type fileError struct {
+	src, dst string
+	err      error
+}
+
+func (e *fileError) Error() string { return e.src + " -> " + e.dst + ": " + e.err.Error() }
+func (e *fileError) Unwrap() error { return e.err }
+
+// fileDone reports that copying the regular file src to dst has finished,
+// with err if it failed. If the destination is full, the copy is stopped, since
+// every file after would fail the same way.
+func (c *copier) fileDone(src, dst FSPath, err error) {
+	if err != nil {
+		c.stats.failed.Add(1)
+		err = &fileError{src.String(), dst.String(), err}
+	}
+	c.p.FileDone(src.String(), dst.String(), err)
+	if errors.Is(err, syscall.ENOSPC) {
+		c.fullWarning.Do(func() {
+			c.p.Error(errors.New("the destination is full; not copying any more files"))
+			c.cancel()
+		})
+	}
+}
+
+// failFast wraps a Progress to cancel the copy at the first error.
+type failFast struct {
+	p      Progress
+	cancel context.CancelFunc
+}
+
+func (ff failFast) Max(n int64)      { ff.p.Max(n) }
+func (ff failFast) Progress(n int64) { ff.p.Progress(n) }
+func (ff failFast) FileStart(src, dst string, size int64) {
+	ff.p.FileStart(src, dst, size)
+}
+func (ff failFast) Warning(err error) { ff.p.Warning(err) }
+
+func (ff failFast) MaxFiles(n int) {
+	if fc, ok := ff.p.(FileCounter); ok {
+		fc.MaxFiles(n)
+	}
+}
+
+func (ff failFast) FileDone(src, dst string, err error) {
+	ff.p.FileDone(src, dst, err)
+	if err != nil {
+		ff.cancel()
+	}
+}
+
+func (ff failFast) Error(err error) {
+	ff.p.Error(err)
+	ff.cancel()
+}
+
+// A root is a source given to Copy and where it's copied to.
+type root struct {
+	src, dst FSPath
+}
+
+// A found is an entry found by the walk in [copier.find], waiting to be
+// copied.
+type found struct {
+	root *root
+	path string
+	d    fs.DirEntry
+	// info describes a regular file or directory. If it couldn't be
+	// read, err is set instead.
+	info fs.FileInfo
+	err  error
+	// weight is how much the entry adds to the total given to Max.
+	weight int64
+}
+
+// findAhead is how many entries the walk in [copier.find] can get ahead of the
+// copy. It's enough for the total to be known before most files are copied,
+// unless there are a great many of them.
+const findAhead = 1 << 16
+
+// find walks each of srcs in turn, sending every entry it finds to out, and
+// closes out when it's done. It stats each entry once, counting up the total
+// progress the copy will report, and gives the total so far to Max every so
+// often and whenever it's waiting for the copy to catch up. A source that can't
+// be copied is reported as an error and left out, as is one that's moved with
+// rename instead.
+func (c *copier) find(srcs []FSPath, dstRoot FSPath, dstIsDir bool, out chan<- *found) {
+	defer close(out)
+	var total int64
+	files := 0
+	defer func() { c.max(total, files) }()
 	for _, srcRoot := range srcs {
+		if c.ctx.Err() != nil {
+			return
+		}
 		dstRoot := dstRoot
-		if dstIsDir {
+		contents := c.TrailingSlash && strings.HasSuffix(srcRoot.Path, "/")
+		if dstIsDir && !contents {
 			// If the destination is a directory, copy into the
 			// existing directory.
 			dstRoot.Path = path.Join(dstRoot.Path, path.Base(srcRoot.Path))
 		}
 		srcRoot.Path = path.Clean(srcRoot.Path)
 		if srcRoot == dstRoot {
-			progress.Error(fmt.Errorf("%q and %q are the same file", srcRoot, dstRoot))
+			c.p.Error(fmt.Errorf("%q and %q are the same file", srcRoot, dstRoot))
+			continue
+		}
+		if srcRoot.FS == dstRoot.FS && within(dstRoot.Path, srcRoot.Path) && srcRoot.isDir() {
+			c.fileDone(srcRoot, dstRoot, errors.New("can't copy a directory into itself"))
+			continue
+		}
+		if n, regular, ok := c.rename(srcRoot, dstRoot); ok {
+			total += n
+			if regular {
+				files++
+			}
+			c.max(total, files)
+			c.p.Progress(n)
 			continue
 		}
-		srcRoot.walkDir(func(srcPath string, d fs.DirEntry, err error) error {
+		r := &root{srcRoot, dstRoot}
+		count := 0
+		srcRoot.walkDir(c.FollowRoots, c.FollowLinks, func(name string, d fs.DirEntry, err error) error {
+			if c.ctx.Err() != nil {
+				return fs.SkipAll
+			}
+			f := &found{root: r, path: name, d: d, err: err}
+			skipDir := false
+			if err == nil {
+				switch d.Type() {
+				case 0: // regular file
+					f.info, f.err = d.Info()
+					if f.err == nil && !c.filtered(f.info) {
+						f.weight = fileWeight(f.info)
+						files++
+					}
+				case fs.ModeDir:
+					f.info, f.err = d.Info()
+					// The contents of a directory that
+					// can't be read won't be copied.
+					skipDir = f.err != nil
+					f.weight = 1
+				default:
+					// Symlinks and special files. Anything
+					// Copy reports as an error counts too,
+					// so that the progress adds up.
+					f.weight = 1
+				}
+			}
+			total += f.weight
+			if count++; count%1024 == 0 {
+				c.max(total, files)
+			}
+			select {
+			case out <- f:
+			default:
+				c.max(total, files)
+				select {
+				case out <- f:
+				case <-c.ctx.Done():
+					return fs.SkipAll
+				}
+			}
+			if skipDir {
+				return fs.SkipDir
+			}
+			return nil
+		})
+		c.max(total, files)
+	}
+}
+
+// max gives the total progress found to copy so far to Max, and the number of
+// regular files among it to MaxFiles if the Progress is a FileCounter.
+func (c *copier) max(total int64, files int) {
+	c.p.Max(total)
+	if fc, ok := c.p.(FileCounter); ok {
+		fc.MaxFiles(files)
+	}
+}
+
+// checkTargetDir returns an error if dstRoot, which sources are to be copied
+// into, exists but isn't a directory. It may not exist yet.
+func checkTargetDir(dstRoot FSPath) error {
+	dstRoot.Path = path.Clean(dstRoot.Path)
+	if stat, err := dstRoot.stat(); err == nil && !stat.IsDir() {
+		return fmt.Errorf("target %q is not a directory", dstRoot)
+	}
+	return nil
+}
+
+// Copy copies srcs into dstRoot, reporting progress using the [Progress]
+// interface. If ctx is cancelled, Copy stops starting new files and abandons
+// the ones in progress.
+func Copy(ctx context.Context, progress Progress, srcs []FSPath, dstRoot FSPath, opts Options) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	summarizer, summarize := progress.(Summarizer)
+	if opts.Logger != nil {
+		progress = logProgress{progress, opts.Logger}
+		opts.Logger.Info("copy started", "sources", len(srcs), "target", dstRoot.String())
+		defer opts.Logger.Info("copy finished")
+	}
+	if opts.FailFast {
+		progress = failFast{progress, cancel}
+	}
+	c := newCopier(ctx, progress, opts)
+	c.cancel = cancel
+	if summarize {
+		// Deferred first, so that it runs once everything else is
+		// done.
+		start := time.Now()
+		defer func() { summarizer.Summary(c.stats.result(opts, time.Since(start))) }()
+	}
+	// TimesOnly comes first, since it must never write any data.
+	if opts.TimesOnly {
+		if _, ok := dstRoot.FS.(streamFS); ok {
+			progress.Error(errors.New("TimesOnly can't be used when copying to a stream"))
+			return
+		}
+		// The times of a gzip file are set like those of any other.
+		srcs = slices.Clone(srcs)
+		for i, src := range srcs {
+			srcs[i] = unwrapGzip(src)
+		}
+		c.touch(srcs, unwrapGzip(dstRoot))
+		return
+	}
+	if gzipping(srcs, dstRoot) {
+		c.gzip(srcs, dstRoot)
+		return
+	}
+	if s, ok := dstRoot.FS.(streamFS); ok {
+		c.stream(srcs, s.w)
+		return
+	}
+
+	// A single source is copied into dstRoot if it's an existing
+	// directory, and otherwise to dstRoot itself, like cp. Several
+	// sources always go into dstRoot, as does a single source if dstRoot
+	// ends in a slash, so that a directory is never mistaken for a file
+	// name. Then dstRoot is created, along with any missing parents, if it
+	// doesn't exist yet, and rejected if it exists but isn't a directory.
+	dstIsDir := true
+	if len(srcs) == 1 && !strings.HasSuffix(dstRoot.Path, "/") {
+		dstIsDir = dstRoot.isDir()
+		if opts.Parents && !dstIsDir && !opts.DryRun {
+			parent := FSPath{dstRoot.FS, path.Dir(dstRoot.Path)}
+			if err := parent.mkdirAll(); err != nil {
+				progress.Error(err)
+				return
+			}
+		}
+	} else if err := checkTargetDir(dstRoot); err != nil {
+		progress.Error(err)
+		return
+	} else if !opts.DryRun && !dstRoot.exists() {
+		if err := dstRoot.mkdirAll(); err != nil {
+			progress.Error(err)
+			return
+		}
+	}
+
+	if _, ok := dstRoot.FS.(wfs.RenameFS); opts.Atomic && !ok {
+		progress.Warning(errors.New("can't copy atomically, since the destination can't rename files"))
+		c.Atomic = false
+	}
+	if c.Atomic && c.TempDir != "" {
+		if err := c.checkTempDir(dstRoot, dstIsDir); err != nil {
+			progress.Error(err)
+			return
+		}
+	}
+	maxConcurrency := opts.Jobs
+	if maxConcurrency <= 0 {
+		maxConcurrency = DefaultJobs
+	}
+	// sem acts as a semaphore to limit the number of concurrent file copies
+	sem := make(chan struct{}, maxConcurrency)
+	// dirFixup records a directory whose mode or times need to be set
+	// after its contents are copied.
+	type dirFixup struct {
+		path     FSPath
+		info     fs.FileInfo
+		readOnly bool
+	}
+	var dirFixups []dirFixup
+	// With Move or RemoveSourceFiles, srcDirs records the source
+	// directories copied, to be removed once they're empty.
+	var srcDirs []FSPath
+	// With Fsync, syncDirs records the destination directories that
+	// entries are created in, to be synced at the end.
+	var syncDirs []FSPath
+	// With Delete, mirror records the destination of every file walked in
+	// a single source directory, so that anything else can be removed.
+	type mirror struct {
+		root       FSPath
+		keep       map[string]bool
+		incomplete bool // Whether the source walk hit any errors
+	}
+	var m *mirror
+	mirroring := c.Delete && len(srcs) == 1 && !c.DryRun
+	dstRoot.Path = path.Clean(dstRoot.Path)
+	// The walk runs ahead of the copy, so that the total is known early
+	// without walking everything twice.
+	entries := make(chan *found, findAhead)
+	go c.find(srcs, dstRoot, dstIsDir, entries)
+	defer func() {
+		// Let the walk finish, so that it doesn't report anything
+		// after Copy returns.
+		for range entries {
+		}
+	}()
+	// skipping is a directory that couldn't be copied, whose contents are
+	// skipped.
+	var skipping *found
+	for f := range entries {
+		if skipping != nil && f.root == skipping.root && within(f.path, skipping.path) {
+			progress.Progress(f.weight)
+			continue
+		}
+		err := func() error {
+			if ctx.Err() != nil {
+				if m != nil {
+					m.incomplete = true
+				}
+				return fs.SkipAll
+			}
+			srcRoot, dstRoot := f.root.src, f.root.dst
+			srcPath, d, err := f.path, f.d, f.err
 			src := FSPath{srcRoot.FS, srcPath}
 			dst := FSPath{dstRoot.FS, path.Join(dstRoot.Path, strings.TrimPrefix(srcPath, srcRoot.Path))}
+			if mirroring && srcPath == srcRoot.Path {
+				mirroring = false
+				m = &mirror{root: dstRoot, keep: make(map[string]bool)}
+			}
+			if srcPath == srcRoot.Path && c.Fsync && !c.DryRun {
+				// The root itself is created in its parent.
+				if parent := (FSPath{dstRoot.FS, path.Dir(dstRoot.Path)}); !slices.Contains(syncDirs, parent) {
+					syncDirs = append(syncDirs, parent)
+				}
+			}
 			if err != nil {
 				progress.Error(err)
+				progress.Progress(f.weight)
+				if m != nil {
+					m.incomplete = true
+				}
 				return nil
 			}
+			if m != nil {
+				if srcPath == srcRoot.Path && !d.IsDir() {
+					// Only a directory can be mirrored.
+					m = nil
+				} else {
+					m.keep[dst.Path] = true
+				}
+			}
 			switch d.Type() {
 			case 0: // regular file
-				sem <- struct{}{}
+				info := f.info
+				if c.filtered(info) {
+					c.stats.skipped.Add(1)
+					return nil
+				}
+				select {
+				case sem <- struct{}{}:
+				case <-ctx.Done():
+					return fs.SkipAll
+				}
+				var link *linkedFile
+				first := false
+				if c.PreserveLinks {
+					link, first = c.trackLink(info, dst)
+				}
 				go func() {
 					defer func() { <-sem }()
-					if err := c.copyRegularFile(src, dst); err != nil {
-						progress.Error(err)
+					ic, settle := c.item(f.weight)
+					var err error
+					if link != nil && !first {
+						err = ic.linkRegularFile(src, dst, info, link)
+					} else {
+						err = ic.copyRegularFile(src, dst, info)
+					}
+					if first {
+						link.err = err
+						close(link.done)
 					}
+					settle()
+					c.fileDone(src, dst, err)
 				}()
 
 			case fs.ModeDir:
-				stat, err := d.Info()
-				if err != nil {
-					progress.Error(err)
-					return fs.SkipDir
+				stat := f.info
+				if c.DryRun {
+					// Walk into the directory without
+					// creating it.
+					progress.Progress(1)
+					return nil
 				}
-				hasWritePerm := stat.Mode()&0300 == 0300
+				mode := c.mode(stat)
+				hasWritePerm := mode&0300 == 0300
+				merged := false
 				if err := c.openWithRetry(dst, func() error {
+					var err error
 					if hasWritePerm {
-						return dst.mkdirMode(stat.Mode().Perm())
+						err = dst.mkdirMode(mode.Perm())
 					} else {
 						// If a directory doesn't have
 						// write permissions, we won't
@@ -271,39 +1316,140 @@ func Copy(progress Progress, srcs []FSPath, dstRoot FSPath, force bool) {
 						// now. So instead create it
 						// with some default
 						// permissions, and append it to
-						// roDirs to be processed later.
-						return dst.mkdir()
+						// dirFixups to be processed
+						// later.
+						err = dst.mkdir()
+					}
+					if err != nil && dst.isDir() {
+						// Like cp -r, copy into an
+						// existing directory rather
+						// than replacing it.
+						merged = true
+						return nil
 					}
+					return err
 				}); err != nil {
 					progress.Error(err)
+					progress.Progress(f.weight)
+					if m != nil {
+						m.incomplete = true
+					}
 					return fs.SkipDir
 				}
+				if c.Fsync {
+					syncDirs = append(syncDirs, dst)
+				}
+				if c.removesSources() {
+					srcDirs = append(srcDirs, src)
+				}
+				if merged {
+					progress.Progress(1)
+					if c.PreserveTimes {
+						// Like cp -p, give an existing
+						// directory the source's times
+						// too, once it's filled.
+						dirFixups = append(dirFixups, dirFixup{dst, stat, false})
+					}
+					return nil
+				}
+				if c.PreserveOwner {
+					if err := c.chown(stat, dst); err != nil {
+						progress.Error(err)
+					}
+				}
+				// mkdir drops the setuid, setgid, and sticky
+				// bits, so they need setting explicitly. A
+				// readonly directory gets them in its fixup.
+				if hasWritePerm && mode != mode.Perm() {
+					if err := dst.chmod(mode); err != nil {
+						progress.Error(err)
+					}
+				}
+				if c.Xattrs {
+					if err := c.copyXattrs(src, dst); err != nil {
+						progress.Error(err)
+					}
+				}
+				if c.ACLs {
+					if err := c.copyACLs(src, dst, true); err != nil {
+						progress.Error(err)
+					}
+				}
 				if hasWritePerm {
 					progress.Progress(1)
-				} else {
-					roDirs = append(roDirs, roDir{dst, stat.Mode().Perm()})
 				}
+				// Copying files into the directory will
+				// update its mtime, so times are also set
+				// later.
+				if !hasWritePerm || c.PreserveTimes {
+					dirFixups = append(dirFixups, dirFixup{dst, stat, !hasWritePerm})
+				}
+			case fs.ModeNamedPipe, fs.ModeSocket, fs.ModeDevice, fs.ModeDevice | fs.ModeCharDevice:
+				ic, settle := c.item(1)
+				if err := ic.copySpecial(src, dst, d); err != nil {
+					progress.Error(err)
+				}
+				settle()
 			case fs.ModeSymlink:
-				if err := c.copySymlink(src, dst); err != nil {
+				ic, settle := c.item(1)
+				if err := ic.copySymlink(src, dst, srcRoot.Path); err != nil {
 					progress.Error(err)
 				}
+				settle()
 			default:
 				progress.Error(fmt.Errorf("%s: unknown file type %s", src, d.Type()))
+				progress.Progress(1)
 			}
 			return nil
-		})
+		}()
+		if err == fs.SkipAll {
+			break
+		}
+		if err == fs.SkipDir {
+			skipping = f
+		}
 	}
 	// Wait for all jobs to complete.
 	for range maxConcurrency {
 		sem <- struct{}{}
 	}
+	if m != nil {
+		switch {
+		case m.incomplete, ctx.Err() != nil:
+			progress.Warning(fmt.Errorf("not deleting extraneous files in %s because of errors reading the source", m.root))
+		case len(m.keep) == 1:
+			// An empty source is more likely a mistake, like
+			// an unmounted disk, than a request to empty the
+			// destination.
+			progress.Warning(fmt.Errorf("not deleting extraneous files in %s because the source is empty", m.root))
+		default:
+			c.deleteExtraneous(m.root, m.keep)
+		}
+	}
 	// Iterate backwards so that directory contents are processed before the
 	// parent directory itself.
-	for _, d := range slices.Backward(roDirs) {
-		if err := d.path.chmod(d.mode); err != nil {
+	for _, d := range slices.Backward(dirFixups) {
+		if d.readOnly {
+			progress.Progress(1)
+			if err := d.path.chmod(c.mode(d.info)); err != nil {
+				progress.Error(err)
+				continue
+			}
+		}
+		if c.PreserveTimes {
+			if err := d.path.chtimes(atime(d.info), d.info.ModTime()); err != nil {
+				progress.Error(err)
+			}
+		}
+	}
+	for _, dir := range slices.Backward(syncDirs) {
+		if err := c.sync(wfs.SyncDir(dir.FS, dir.Path), dir); err != nil {
 			progress.Error(err)
-			continue
 		}
-		progress.Progress(1)
+	}
+	for _, dir := range slices.Backward(srcDirs) {
+		// A directory that still has files in it, because they
+		// weren't moved, is left alone.
+		dir.remove()
 	}
 }
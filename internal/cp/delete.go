@@ -0,0 +1,31 @@
+package cp
+
+import (
+	"io/fs"
+	"path"
+)
+
+// deleteExtraneous removes everything in the tree rooted at root whose path
+// isn't in keep, other than backups. Symlinks in the destination are removed,
+// never followed.
+func (c *copier) deleteExtraneous(root FSPath, keep map[string]bool) {
+	if !keep[root.Path] || path.Clean(root.Path) == "/" {
+		return
+	}
+	fs.WalkDir(root.FS, root.Path, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			c.p.Error(err)
+			return nil
+		}
+		if keep[name] || !d.IsDir() && c.isBackup(name) {
+			return nil
+		}
+		if err := (FSPath{root.FS, name}).removeAll(); err != nil {
+			c.p.Error(err)
+		}
+		if d.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	})
+}
@@ -0,0 +1,188 @@
+package cp
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/rhogenson/ccp/internal/wfs"
+)
+
+// Gzip returns p as a gzip file. Given to [Copy] as its only source, p is
+// decompressed on the way; as the destination, the single regular file copied
+// to it is compressed. Either way p is a single file, not a directory.
+func Gzip(p FSPath) FSPath {
+	return FSPath{FS: gzipFS{p.FS}, Path: p.Path}
+}
+
+// gzipFS marks a path returned by Gzip. Copy recognizes it and copies the file
+// through gzip itself, using the file system underneath.
+type gzipFS struct{ wfs.FS }
+
+func isGzip(p FSPath) bool {
+	_, ok := p.FS.(gzipFS)
+	return ok
+}
+
+// unwrapGzip returns p on the file system underneath, if it is a gzip file.
+func unwrapGzip(p FSPath) FSPath {
+	if g, ok := p.FS.(gzipFS); ok {
+		p.FS = g.FS
+	}
+	return p
+}
+
+// gzip copies the single regular file in srcs to dst, compressing or
+// decompressing it. A decompressed file copied into a directory is named after
+// the source, without the .gz.
+func (c *copier) gzip(srcs []FSPath, dst FSPath) {
+	if len(srcs) != 1 {
+		c.p.Error(errors.New("only a single file can be copied to or from a gzip file"))
+		return
+	}
+	// The copy's contents differ from the source's, so they can't be
+	// verified or resumed.
+	if c.Verify || c.Resume || c.Atomic {
+		c.p.Error(errors.New("Verify, Resume, and Atomic can't be used when copying to or from a gzip file"))
+		return
+	}
+	src := srcs[0]
+	stat, err := src.stat()
+	if err != nil {
+		c.p.Error(err)
+		return
+	}
+	if !stat.Mode().IsRegular() {
+		c.p.Error(fmt.Errorf("%s: not a regular file", src))
+		return
+	}
+	if _, ok := dst.FS.(streamFS); !ok {
+		if !isGzip(dst) && dst.isDir() {
+			dst.Path = path.Join(dst.Path, strings.TrimSuffix(path.Base(src.Path), ".gz"))
+		} else if c.Parents && !c.DryRun {
+			parent := FSPath{unwrapGzip(dst).FS, path.Dir(dst.Path)}
+			if err := parent.mkdirAll(); err != nil {
+				c.p.Error(err)
+				return
+			}
+		}
+	}
+	// Progress counts the bytes of the source file as it is, compressed
+	// or not, so that the total is known up front.
+	weight := fileWeight(stat)
+	c.max(weight, 1)
+	ic, settle := c.item(weight)
+	err = ic.copyGzip(src, dst, stat)
+	settle()
+	c.fileDone(src, dst, err)
+}
+
+func (c *copier) copyGzip(src, dst FSPath, info fs.FileInfo) error {
+	c.p.FileStart(src.String(), dst.String(), info.Size())
+	// The rest of the copy, backing up dst and removing src, goes through
+	// the file systems underneath.
+	decompress, compress := isGzip(src), isGzip(dst)
+	src, dst = unwrapGzip(src), unwrapGzip(dst)
+	_, stream := dst.FS.(streamFS)
+	if !stream && (c.skip(info, dst) || !c.confirmOverwrite(dst)) {
+		c.stats.skipped.Add(1)
+		return nil
+	}
+	if c.DryRun {
+		c.record(src, dst, info)
+		return nil
+	}
+	if !stream {
+		if err := c.backup(dst); err != nil {
+			return err
+		}
+	}
+	f, err := src.open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var in io.Reader = &readProgress{c.ctx, f, c.p}
+	if decompress {
+		zr, err := gzip.NewReader(in)
+		if err != nil {
+			return err
+		}
+		in = zr
+	}
+	var out io.WriteCloser
+	if stream {
+		out = nopCloser{dst.FS.(streamFS).w}
+	} else if err := c.openWithRetry(dst, func() error {
+		var err error
+		out, err = dst.create(c.mode(info).Perm())
+		return err
+	}); err != nil {
+		return err
+	}
+	w := io.Writer(out)
+	var zw *gzip.Writer
+	if compress {
+		zw = gzip.NewWriter(out)
+		zw.Name = strings.TrimSuffix(path.Base(src.Path), ".gz")
+		zw.ModTime = info.ModTime()
+		w = zw
+	}
+	buf := c.buffers.Get().(*[]byte)
+	defer c.buffers.Put(buf)
+	_, err = io.CopyBuffer(w, in, *buf)
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	if err == nil && c.Fsync && !stream {
+		err = c.sync(wfs.Sync(out), dst)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if !stream {
+			c.abandon(dst)
+		}
+		return err
+	}
+	if !stream {
+		if err := c.copyAttrs(src, dst, info); err != nil {
+			return err
+		}
+		c.record(src, dst, info)
+	}
+	return c.removeSource(src)
+}
+
+// A readProgress reports the progress of reading a file, and stops once ctx is
+// done.
+type readProgress struct {
+	ctx context.Context
+	r   io.Reader
+	p   Progress
+}
+
+func (r *readProgress) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(b)
+	r.p.Progress(int64(n))
+	return n, err
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// gzipping reports whether srcs or dst were given by Gzip.
+func gzipping(srcs []FSPath, dst FSPath) bool {
+	return isGzip(dst) || slices.ContainsFunc(srcs, isGzip)
+}
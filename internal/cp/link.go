@@ -0,0 +1,71 @@
+package cp
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// A linkedFile is the first copy of a source file that has multiple hard links.
+type linkedFile struct {
+	dst  FSPath
+	done chan struct{} // Closed once the copy has finished
+	err  error         // The result of the copy, valid once done is closed
+}
+
+// trackLink looks up the source file described by info, if it has multiple
+// hard links. If the file has been seen before, trackLink returns its first
+// copy. Otherwise it records dst as the first copy and reports first as true.
+func (c *copier) trackLink(info fs.FileInfo, dst FSPath) (link *linkedFile, first bool) {
+	if nlink(info) < 2 {
+		return nil, false
+	}
+	id, ok := identity(info)
+	if !ok {
+		return nil, false
+	}
+	if link, ok := c.links[id]; ok {
+		return link, false
+	}
+	link = &linkedFile{dst: dst, done: make(chan struct{})}
+	c.links[id] = link
+	return link, true
+}
+
+// linkRegularFile makes dst a hard link to first.dst, the copy of another link
+// to the same source file as src, which is described by info. If that copy
+// failed, or the destination doesn't support hard links, src is copied as
+// usual.
+func (c *copier) linkRegularFile(src, dst FSPath, info fs.FileInfo, first *linkedFile) error {
+	<-first.done
+	if first.err != nil {
+		return c.copyRegularFile(src, dst, info)
+	}
+	c.p.FileStart(src.String(), dst.String(), info.Size())
+	if c.skip(info, dst) || !c.confirmOverwrite(dst) {
+		c.stats.skipped.Add(1)
+		return nil
+	}
+	if c.DryRun {
+		c.record(src, dst, info)
+		return nil
+	}
+	if err := c.backup(dst); err != nil {
+		return err
+	}
+	err := dst.linkTo(first.dst)
+	if errors.Is(err, fs.ErrExist) {
+		// Replace the existing file, as copying would.
+		if err := dst.removeAll(); err != nil {
+			return err
+		}
+		err = dst.linkTo(first.dst)
+	}
+	if errors.Is(err, errors.ErrUnsupported) {
+		err = c.copyFile(src, dst, info)
+	}
+	if err != nil {
+		return err
+	}
+	c.record(src, dst, info)
+	return c.removeSource(src)
+}
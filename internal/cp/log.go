@@ -0,0 +1,44 @@
+package cp
+
+import "log/slog"
+
+// logProgress wraps a Progress to also log what's reported to Options.Logger:
+// each regular file at debug level as it's started and finished, and errors and
+// warnings at their own levels.
+type logProgress struct {
+	p   Progress
+	log *slog.Logger
+}
+
+func (lp logProgress) Max(n int64)      { lp.p.Max(n) }
+func (lp logProgress) Progress(n int64) { lp.p.Progress(n) }
+
+func (lp logProgress) MaxFiles(n int) {
+	if fc, ok := lp.p.(FileCounter); ok {
+		fc.MaxFiles(n)
+	}
+}
+
+func (lp logProgress) FileStart(src, dst string, size int64) {
+	lp.log.Debug("copying file", "src", src, "dst", dst, "size", size)
+	lp.p.FileStart(src, dst, size)
+}
+
+func (lp logProgress) FileDone(src, dst string, err error) {
+	if err != nil {
+		lp.log.Error("file failed", "src", src, "dst", dst, "err", err)
+	} else {
+		lp.log.Debug("file done", "src", src, "dst", dst)
+	}
+	lp.p.FileDone(src, dst, err)
+}
+
+func (lp logProgress) Error(err error) {
+	lp.log.Error("error", "err", err)
+	lp.p.Error(err)
+}
+
+func (lp logProgress) Warning(err error) {
+	lp.log.Warn("warning", "err", err)
+	lp.p.Warning(err)
+}
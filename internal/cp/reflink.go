@@ -0,0 +1,55 @@
+package cp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// A Reflink says whether to clone files rather than copy their data, like cp's
+// --reflink option. A clone shares the source's data blocks until either file
+// is changed, on file systems that support it, such as Btrfs and XFS.
+type Reflink int
+
+const (
+	// ReflinkAuto clones a file when it and its copy are on a local file
+	// system that supports cloning, and otherwise copies it.
+	ReflinkAuto Reflink = iota
+	// ReflinkAlways clones files, and fails to copy those it can't clone.
+	ReflinkAlways
+	// ReflinkNever always copies the data, without cloning and without
+	// copy_file_range, which some file systems implement by cloning.
+	ReflinkNever
+)
+
+// errNoClone is the reason a file can't be cloned when it isn't local.
+var errNoClone = errors.New("not a local file")
+
+// reflink clones the local file in to out, if Reflink allows it, and reports
+// whether it did. With ReflinkAlways, failing to clone is an error.
+func (c *copier) reflink(out io.Writer, in io.Reader, src, dst FSPath, size int64) (bool, error) {
+	if c.Reflink == ReflinkNever {
+		return false, nil
+	}
+	err := errNoClone
+	f, ok1 := in.(*os.File)
+	w, ok2 := out.(*os.File)
+	if ok1 && ok2 {
+		err = clone(w, f)
+	}
+	if err != nil {
+		if c.Reflink == ReflinkAlways {
+			return false, fmt.Errorf("can't clone %s to %s: %w", src, dst, err)
+		}
+		return false, nil
+	}
+	c.p.Progress(size)
+	return true, nil
+}
+
+// onlyWriter hides any methods of an io.Writer other than Write, such as
+// ReadFrom, so that io.Copy writes through a buffer.
+type onlyWriter struct {
+	io.Writer
+}
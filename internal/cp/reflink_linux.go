@@ -0,0 +1,11 @@
+package cp
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func clone(dst, src *os.File) error {
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package cp
+
+import (
+	"errors"
+	"os"
+)
+
+func clone(*os.File, *os.File) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,58 @@
+package cp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// A Result summarizes a finished [Copy], for a [Summarizer].
+type Result struct {
+	// Files is the number of regular files copied, and Bytes is their
+	// total size. With DryRun, they count the files that would have been
+	// copied, and with TimesOnly, the files whose times were set.
+	Files int
+	Bytes int64
+	// DryRun and TimesOnly are those of the copy's Options, saying what
+	// Files and Bytes count.
+	DryRun, TimesOnly bool
+	// Skipped is the number of regular files left alone, such as by
+	// Update, NoClobber, or MinSize, or because ConfirmOverwrite declined.
+	Skipped int
+	// Failed is the number of regular files that couldn't be copied.
+	// Other errors, like a directory that couldn't be read, are only
+	// reported to Progress.Error.
+	Failed   int
+	Duration time.Duration
+}
+
+// A Summarizer is a [Progress] that's also given a [Result] once the copy is
+// finished. Copy checks whether the Progress it's given is a Summarizer.
+type Summarizer interface {
+	Progress
+	// Summary is called once, after everything else has been reported.
+	Summary(Result)
+}
+
+// stats counts the regular files in a copy, for its Result.
+type stats struct {
+	files, skipped, failed, bytes atomic.Int64
+}
+
+// result returns the Result of a copy with opts, which took d.
+func (s *stats) result(opts Options, d time.Duration) Result {
+	return Result{
+		Files:     int(s.files.Load()),
+		Bytes:     s.bytes.Load(),
+		DryRun:    opts.DryRun,
+		TimesOnly: opts.TimesOnly,
+		Skipped:   int(s.skipped.Load()),
+		Failed:    int(s.failed.Load()),
+		Duration:  d,
+	}
+}
+
+// copied counts the regular file described by info as copied.
+func (s *stats) copied(size int64) {
+	s.files.Add(1)
+	s.bytes.Add(size)
+}
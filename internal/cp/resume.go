@@ -0,0 +1,52 @@
+package cp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
+
+	"github.com/rhogenson/ccp/internal/wfs"
+)
+
+// openResume opens dst to continue an interrupted copy from in, if dst holds a
+// prefix of the source. On success, in is positioned just past the part of the
+// source that's already been copied and the returned writer is positioned at
+// the end of dst. If the copy can't be resumed, openResume returns a nil writer
+// and in is positioned at the beginning of the source.
+func (c *copier) openResume(in fs.File, src fs.FileInfo, dst FSPath) (io.WriteCloser, error) {
+	stat, err := dst.stat()
+	if err != nil || !stat.Mode().IsRegular() || stat.Size() == 0 || stat.Size() > src.Size() {
+		return nil, nil
+	}
+	// Reading the prefix of in to hash it conveniently leaves it at the
+	// right position to continue the copy.
+	h := sha256.New()
+	if _, err := io.CopyN(h, in, stat.Size()); err != nil {
+		return nil, err
+	}
+	dstSum, err := dst.sha256sum()
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(h.Sum(nil), dstSum) {
+		out, err := wfs.OpenAppend(dst.FS, dst.Path)
+		if err == nil {
+			c.p.Progress(stat.Size())
+			return out, nil
+		}
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return nil, err
+		}
+	}
+	// Start over from the beginning.
+	seeker, ok := in.(io.Seeker)
+	if !ok {
+		return nil, &fs.PathError{Op: "seek", Path: dst.String(), Err: errors.ErrUnsupported}
+	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,57 @@
+package cp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// A sparseWriter is a destination file that holes can be left in.
+type sparseWriter interface {
+	io.WriterAt
+	Truncate(size int64) error
+}
+
+// copySparse copies the data regions of the local file in to out at the same
+// offsets, skipping the holes between them, and then extends out to size. It
+// reports false without writing anything if either file doesn't support this.
+// The holes are reported as progress, so the total still adds up.
+func (c *copier) copySparse(out io.Writer, in io.Reader, size int64) (bool, error) {
+	f, ok := in.(*os.File)
+	if !ok {
+		return false, nil
+	}
+	w, ok := out.(sparseWriter)
+	if !ok {
+		return false, nil
+	}
+	if _, err := seekData(f, 0); err != nil && !errors.Is(err, syscall.ENXIO) {
+		return false, nil
+	}
+	for off := int64(0); off < size; {
+		data, err := seekData(f, off)
+		if errors.Is(err, syscall.ENXIO) {
+			// The rest of the file is a hole.
+			data = size
+		} else if err != nil {
+			return true, err
+		}
+		c.p.Progress(data - off)
+		if data >= size {
+			break
+		}
+		hole, err := seekHole(f, data)
+		if err != nil {
+			return true, err
+		}
+		if _, err := f.Seek(data, io.SeekStart); err != nil {
+			return true, err
+		}
+		if err := c.copyData(io.NewOffsetWriter(w, data), io.LimitReader(f, hole-data)); err != nil {
+			return true, err
+		}
+		off = hole
+	}
+	return true, w.Truncate(size)
+}
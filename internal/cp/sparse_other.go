@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+package cp
+
+import (
+	"errors"
+	"os"
+)
+
+func seekData(*os.File, int64) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func seekHole(*os.File, int64) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
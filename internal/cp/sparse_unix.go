@@ -0,0 +1,17 @@
+//go:build linux || darwin
+
+package cp
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func seekData(f *os.File, off int64) (int64, error) {
+	return f.Seek(off, unix.SEEK_DATA)
+}
+
+func seekHole(f *os.File, off int64) (int64, error) {
+	return f.Seek(off, unix.SEEK_HOLE)
+}
@@ -0,0 +1,66 @@
+package cp
+
+import (
+	"archive/tar"
+	"io/fs"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// atime returns the access time of fi, falling back to the modification time
+// if the backing file system doesn't report one.
+func atime(fi fs.FileInfo) time.Time {
+	switch sys := fi.Sys().(type) {
+	case *sftp.FileStat:
+		return time.Unix(int64(sys.Atime), 0)
+	case *tar.Header:
+		if !sys.AccessTime.IsZero() {
+			return sys.AccessTime
+		}
+	default:
+		if t, ok := sysAtime(sys); ok {
+			return t
+		}
+	}
+	return fi.ModTime()
+}
+
+// owner returns the numeric uid and gid of fi, if the backing file system
+// reports them.
+func owner(fi fs.FileInfo) (uid, gid int, ok bool) {
+	switch sys := fi.Sys().(type) {
+	case *sftp.FileStat:
+		return int(sys.UID), int(sys.GID), true
+	case *tar.Header:
+		return sys.Uid, sys.Gid, true
+	default:
+		return sysOwner(sys)
+	}
+}
+
+// A fileID uniquely identifies a file on the local machine.
+type fileID struct {
+	dev, ino uint64
+}
+
+// identity returns the device and inode number of fi, if the backing file
+// system reports them. SFTP doesn't expose inode numbers.
+func identity(fi fs.FileInfo) (fileID, bool) {
+	return sysFileID(fi.Sys())
+}
+
+// rdev returns the device number of the device node fi, if the backing file
+// system reports it.
+func rdev(fi fs.FileInfo) (uint64, bool) {
+	return sysRdev(fi.Sys())
+}
+
+// nlink returns the number of hard links to fi, or 1 if the backing file system
+// doesn't report it.
+func nlink(fi fs.FileInfo) uint64 {
+	if n, ok := sysNlink(fi.Sys()); ok {
+		return n
+	}
+	return 1
+}
@@ -0,0 +1,46 @@
+package cp
+
+import (
+	"syscall"
+	"time"
+)
+
+func sysAtime(sys any) (time.Time, bool) {
+	st, ok := sys.(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(st.Atimespec.Unix()), true
+}
+
+func sysOwner(sys any) (uid, gid int, ok bool) {
+	st, ok := sys.(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
+
+func sysFileID(sys any) (fileID, bool) {
+	st, ok := sys.(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{uint64(st.Dev), uint64(st.Ino)}, true
+}
+
+func sysRdev(sys any) (uint64, bool) {
+	st, ok := sys.(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(uint32(st.Rdev)), true
+}
+
+func sysNlink(sys any) (uint64, bool) {
+	st, ok := sys.(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Nlink), true
+}
@@ -0,0 +1,25 @@
+//go:build !linux && !darwin
+
+package cp
+
+import "time"
+
+func sysAtime(any) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func sysOwner(any) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+func sysFileID(any) (fileID, bool) {
+	return fileID{}, false
+}
+
+func sysRdev(any) (uint64, bool) {
+	return 0, false
+}
+
+func sysNlink(any) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,65 @@
+package cp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rhogenson/ccp/internal/wfs"
+)
+
+// Stream returns a destination for [Copy] that writes the contents of a single
+// regular file to w, such as os.Stdout, rather than creating any files.
+func Stream(w io.Writer) FSPath {
+	return FSPath{FS: streamFS{w: w}, Path: "-"}
+}
+
+// streamFS marks a destination created by Stream. Copy recognizes it and
+// never calls its file system methods.
+type streamFS struct {
+	wfs.FS
+	w io.Writer
+}
+
+// stream copies the single regular file in srcs to w.
+func (c *copier) stream(srcs []FSPath, w io.Writer) {
+	if len(srcs) != 1 {
+		c.p.Error(errors.New("only a single file can be copied to a stream"))
+		return
+	}
+	src := srcs[0]
+	stat, err := src.stat()
+	if err != nil {
+		c.p.Error(err)
+		return
+	}
+	if !stat.Mode().IsRegular() {
+		c.p.Error(fmt.Errorf("%s: not a regular file", src))
+		return
+	}
+	c.max(stat.Size()+1, 1)
+	c.p.FileStart(src.String(), "-", stat.Size())
+	if c.DryRun {
+		c.p.Progress(stat.Size() + 1)
+	} else {
+		err = c.streamContents(src, w)
+	}
+	if err == nil {
+		c.stats.copied(stat.Size())
+		err = c.removeSource(src)
+	}
+	c.fileDone(src, Stream(w), err)
+}
+
+func (c *copier) streamContents(src FSPath, w io.Writer) error {
+	in, err := src.open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := c.copyData(w, in); err != nil {
+		return err
+	}
+	c.p.Progress(1)
+	return nil
+}
@@ -0,0 +1,32 @@
+package cp
+
+// A tally wraps a Progress to count the progress reported for a single walked
+// entry.
+type tally struct {
+	p Progress
+	n int64
+}
+
+func (t *tally) Max(n int64)                           { t.p.Max(n) }
+func (t *tally) FileStart(src, dst string, size int64) { t.p.FileStart(src, dst, size) }
+func (t *tally) FileDone(src, dst string, err error)   { t.p.FileDone(src, dst, err) }
+func (t *tally) Error(err error)                       { t.p.Error(err) }
+func (t *tally) Warning(err error)                     { t.p.Warning(err) }
+
+func (t *tally) Progress(n int64) {
+	t.n += n
+	t.p.Progress(n)
+}
+
+// item returns a copier for copying a single walked entry, which was counted
+// as want in the total, and a function to call once the entry is done with. Whether
+// the entry was copied, skipped, or failed part way through, settle brings the
+// progress reported for it to exactly want, so that the progress adds up to
+// the total when the copy finishes.
+func (c *copier) item(want int64) (ic *copier, settle func()) {
+	t := &tally{p: c.p}
+	ic = new(copier)
+	*ic = *c
+	ic.p = t
+	return ic, func() { c.p.Progress(want - t.n) }
+}
@@ -0,0 +1,77 @@
+package cp
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// touch sets the times of each file in the tree that srcs would be copied to,
+// for TimesOnly, wherever its modification time differs from its source's.
+// Nothing is created or copied: a destination that doesn't exist, or isn't the
+// same type of file as its source, is left alone.
+func (c *copier) touch(srcs []FSPath, dstRoot FSPath) {
+	dstIsDir := len(srcs) != 1 || strings.HasSuffix(dstRoot.Path, "/") || dstRoot.isDir()
+	dstRoot.Path = path.Clean(dstRoot.Path)
+	entries := make(chan *found, findAhead)
+	go c.find(srcs, dstRoot, dstIsDir, entries)
+	defer func() {
+		for range entries {
+		}
+	}()
+	for f := range entries {
+		if c.ctx.Err() != nil {
+			break
+		}
+		if err := c.touchEntry(f); err != nil {
+			c.p.Error(err)
+		}
+		c.p.Progress(f.weight)
+	}
+}
+
+func (c *copier) touchEntry(f *found) error {
+	if f.err != nil {
+		return f.err
+	}
+	src := FSPath{f.root.src.FS, f.path}
+	dst := FSPath{f.root.dst.FS, path.Join(f.root.dst.Path, strings.TrimPrefix(f.path, f.root.src.Path))}
+	info := f.info
+	if info == nil {
+		// The walk only reads the info of regular files and
+		// directories.
+		var err error
+		if info, err = f.d.Info(); err != nil {
+			return err
+		}
+	}
+	if info.Mode().IsRegular() && c.filtered(info) {
+		return nil
+	}
+	dstInfo, err := dst.lstat()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if dstInfo.Mode().Type() != info.Mode().Type() || dstInfo.ModTime().Equal(info.ModTime()) || c.DryRun {
+		return nil
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if err := dst.lchtimes(atime(info), info.ModTime()); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+		return nil
+	}
+	if info.Mode().IsRegular() {
+		c.p.FileStart(src.String(), dst.String(), info.Size())
+		err := dst.chtimes(atime(info), info.ModTime())
+		if err == nil {
+			c.stats.files.Add(1)
+		}
+		c.fileDone(src, dst, err)
+		return nil
+	}
+	return dst.chtimes(atime(info), info.ModTime())
+}
@@ -0,0 +1,180 @@
+package cp
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+// maxLinkDepth bounds how many symlinked directories can be nested inside each
+// other when following links on file systems that don't report inode numbers.
+const maxLinkDepth = 40
+
+var errLinkLoop = errors.New("symbolic link loop")
+
+// walkDir walks the file tree rooted at p. If followRoot is false and p is a
+// symlink, fn is called for the symlink itself rather than its target. If
+// followLinks is set, every symlink in the tree is followed, so fn is only
+// called for the files they point to. Large directories are walked as they're
+// read; see [walk].
+func (p FSPath) walkDir(followRoot, followLinks bool, fn fs.WalkDirFunc) error {
+	if followLinks {
+		w := &linkWalker{
+			fsys:   p.FS,
+			fn:     fn,
+			active: make(map[fileID]bool),
+		}
+		return w.walk(p.Path)
+	}
+	if !followRoot {
+		if stat, err := p.lstat(); err == nil && stat.Mode()&fs.ModeSymlink != 0 {
+			return fn(p.Path, fs.FileInfoToDirEntry(stat), nil)
+		}
+	}
+	return walk(p.FS, p.Path, fn)
+}
+
+// dirBatch is the number of directory entries read at a time by [walk].
+const dirBatch = 1024
+
+// walk walks the file tree rooted at root like [fs.WalkDir], except that a
+// directory that can be read incrementally, as an [fs.ReadDirFile], is walked a
+// batch of entries at a time. That way a directory with millions of entries
+// isn't held in memory all at once, and its first files can be copied without
+// waiting for the whole listing. A directory with fewer than dirBatch entries
+// is still walked in lexical order; the entries of a larger one come in the
+// order the file system returns them.
+func walk(fsys fs.FS, root string, fn fs.WalkDirFunc) error {
+	info, err := fs.Stat(fsys, root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkEntry(fsys, root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkEntry(fsys fs.FS, name string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(name, d, nil); err != nil || !d.IsDir() {
+		if err == fs.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+	err := readDir(fsys, name, func(entries []fs.DirEntry) error {
+		for _, d1 := range entries {
+			if err := walkEntry(fsys, path.Join(name, d1.Name()), d1, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == fs.SkipDir {
+		return nil
+	}
+	var readErr *dirReadError
+	if errors.As(err, &readErr) {
+		// Give fn a chance to skip the directory, as fs.WalkDir does.
+		if err = fn(name, d, readErr.err); err == fs.SkipDir {
+			err = nil
+		}
+	}
+	return err
+}
+
+// A dirReadError is an error reading a directory, as opposed to one returned
+// by the function walking its entries.
+type dirReadError struct{ err error }
+
+func (e *dirReadError) Error() string { return e.err.Error() }
+
+// readDir reads the directory name and calls fn with its entries, a batch at a
+// time if the directory can be read incrementally, and otherwise all at once.
+func readDir(fsys fs.FS, name string, fn func([]fs.DirEntry) error) error {
+	var dir fs.ReadDirFile
+	if _, ok := fsys.(fs.ReadDirFS); !ok {
+		// File systems implementing ReadDirFS have their own way
+		// of listing a directory, which they'd rather be used.
+		if f, err := fsys.Open(name); err == nil {
+			if dir, ok = f.(fs.ReadDirFile); ok {
+				defer dir.Close()
+			} else {
+				f.Close()
+			}
+		}
+	}
+	if dir == nil {
+		entries, err := fs.ReadDir(fsys, name)
+		if err := fn(entries); err != nil {
+			return err
+		}
+		if err != nil {
+			return &dirReadError{err}
+		}
+		return nil
+	}
+	entries, err := dir.ReadDir(dirBatch)
+	if len(entries) < dirBatch {
+		// The whole directory fit in one batch, so walk it in order.
+		slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+			return strings.Compare(a.Name(), b.Name())
+		})
+	}
+	for {
+		if err := fn(entries); err != nil {
+			return err
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &dirReadError{err}
+		}
+		entries, err = dir.ReadDir(dirBatch)
+	}
+}
+
+// A linkWalker walks a file tree like [fs.WalkDir], but follows symlinks.
+type linkWalker struct {
+	fsys fs.FS
+	fn   fs.WalkDirFunc
+	// active holds the directories currently being walked through a
+	// symlink, to detect cycles.
+	active map[fileID]bool
+	depth  int
+}
+
+func (w *linkWalker) walk(root string) error {
+	stat, err := fs.Stat(w.fsys, root)
+	if err != nil {
+		return w.fn(root, nil, err)
+	}
+	if !stat.IsDir() {
+		return w.fn(root, fs.FileInfoToDirEntry(stat), nil)
+	}
+	if id, ok := identity(stat); ok {
+		if w.active[id] {
+			return w.fn(root, nil, &fs.PathError{Op: "walk", Path: root, Err: errLinkLoop})
+		}
+		w.active[id] = true
+		defer delete(w.active, id)
+	} else {
+		if w.depth >= maxLinkDepth {
+			return w.fn(root, nil, &fs.PathError{Op: "walk", Path: root, Err: errLinkLoop})
+		}
+		w.depth++
+		defer func() { w.depth-- }()
+	}
+	return walk(w.fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if err == nil && name != root && d.Type() == fs.ModeSymlink {
+			return w.walk(name)
+		}
+		return w.fn(name, d, err)
+	})
+}
@@ -5,47 +5,82 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 
 	runewidth "github.com/mattn/go-runewidth"
+	"golang.org/x/term"
 )
 
 // Renderer updates a terminal UI. Typical usage looks like
 //
 //	r := render.New()
 //	// Game loop
-//	for {
+//	for !done {
 //	    // Update state
 //
 //	    r.Clear()
 //	    fmt.Fprintf(r, "Render UI by writing to r using io.Writer")
 //	    r.Flush()
 //	}
+//	r.Finish()
 type Renderer struct {
 	w              bufio.Writer
-	prevLines      int
+	plain          bool         // Whether w isn't a terminal, so frames aren't drawn
+	frame          bytes.Buffer // The latest frame, if plain
+	lineWidths     []int        // The width of each line of the previous frame
 	width          int
 	partialLineLen int
 }
 
-// New creates a new Renderer
+// New creates a new Renderer that draws on stderr.
 func New() *Renderer {
+	return NewWriter(os.Stderr)
+}
+
+// NewWriter creates a new Renderer that writes its output, escape sequences and
+// all, to w. w is usually a terminal, but it could also be a pty or a buffer.
+//
+// If w is a file that isn't a terminal, such as a pipe, the Renderer doesn't
+// draw frames, which would fill it with escape sequences. Lines printed with
+// Println are written as they are, and the last frame is written by Finish.
+func NewWriter(w io.Writer) *Renderer {
 	r := &Renderer{}
-	r.w.Reset(os.Stderr)
+	if f, ok := w.(interface{ Fd() uintptr }); ok && !term.IsTerminal(int(f.Fd())) {
+		r.plain = true
+	}
+	r.w.Reset(w)
 	return r
 }
 
-// Clear clears the screen before rendering a new frame.
+// Clear clears the screen before rendering a new frame, which is truncated to
+// width columns. If the terminal has been resized since the last frame, width
+// should be its new width.
 func (r *Renderer) Clear(width int) {
+	if r.plain {
+		r.frame.Reset()
+		return
+	}
+	rows := len(r.lineWidths)
+	if 0 < width && width < r.width {
+		// The terminal has shrunk, so it may have wrapped each line
+		// of the previous frame onto several rows.
+		rows = 0
+		for _, w := range r.lineWidths {
+			rows += max(1, (w+width-1)/width)
+		}
+	}
 	r.width = width
-	if r.prevLines > 0 {
-		fmt.Fprintf(&r.w, "\033[%dA", r.prevLines)
+	if rows > 0 {
+		fmt.Fprintf(&r.w, "\033[%dA", rows)
 	}
 	r.w.WriteString("\r")
-	r.prevLines = 0
+	r.lineWidths = r.lineWidths[:0]
 	r.partialLineLen = 0
 }
 
+// truncate returns the longest prefix of b that fits in width columns, not
+// counting escape sequences, and how many columns it takes up.
 func truncate(b []byte, width int) ([]byte, int) {
 	str := string(b)
 	currentWidth := 0
@@ -70,14 +105,33 @@ func truncate(b []byte, width int) ([]byte, int) {
 			currentWidth += chunkWidth
 			continue
 		}
+		// Truncate works on whole grapheme clusters, so a wide
+		// character or a character with combining marks is never
+		// split. If a wide character doesn't fit, the cell left over
+		// stays empty, so the width returned can be less than width.
 		lastChunk := runewidth.Truncate(str[i:i+chunkBytes], width-currentWidth, "")
-		return b[:i+len(lastChunk)], width
+		return b[:i+len(lastChunk)], currentWidth + runewidth.StringWidth(lastChunk)
 	}
 	return b, currentWidth
 }
 
+// Println prints a line above the UI that stays on the screen after the next
+// frame is drawn, like a log message. Println must be called right after Clear,
+// before any of the frame is written.
+func (r *Renderer) Println(line string) {
+	r.w.WriteString(line)
+	if r.plain {
+		r.w.WriteString("\n")
+		return
+	}
+	r.w.WriteString("\033[K\n")
+}
+
 // Write implements io.Writer.
 func (r *Renderer) Write(buf []byte) (int, error) {
+	if r.plain {
+		return r.frame.Write(buf)
+	}
 	totalBytes := 0
 	for len(buf) > 0 {
 		i := bytes.IndexByte(buf, '\n')
@@ -90,7 +144,7 @@ func (r *Renderer) Write(buf []byte) (int, error) {
 			totalBytes += len(buf)
 			return totalBytes, nil
 		}
-		line, _ := truncate(buf[:i], r.width)
+		line, lineWidth := truncate(buf[:i], r.width-r.partialLineLen)
 		buf = buf[i+1:]
 		if n, err := r.w.Write(line); err != nil {
 			return totalBytes + n, err
@@ -100,15 +154,28 @@ func (r *Renderer) Write(buf []byte) (int, error) {
 			return totalBytes, err
 		}
 		totalBytes++
-		r.prevLines++
+		r.lineWidths = append(r.lineWidths, r.partialLineLen+lineWidth)
 		r.partialLineLen = 0
 	}
 	return totalBytes, nil
 }
 
-// Flush flushes the internal buffer to stdout. Flush should be called at the
-// end of every frame.
+// Flush flushes the internal buffer to the underlying writer. Flush should be
+// called at the end of every frame.
 func (r *Renderer) Flush() {
-	r.w.WriteString("\033[J")
+	if !r.plain {
+		r.w.WriteString("\033[J")
+	}
+	r.w.Flush()
+}
+
+// Finish leaves the last frame on the screen, once the UI is no longer being
+// updated. If the Renderer isn't drawing frames, Finish writes out the last
+// one.
+func (r *Renderer) Finish() {
+	if r.plain {
+		r.w.Write(r.frame.Bytes())
+		r.frame.Reset()
+	}
 	r.w.Flush()
 }
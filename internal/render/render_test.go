@@ -0,0 +1,104 @@
+package render
+
+import "testing"
+
+func TestTruncate(t *testing.T) {
+	for _, tc := range []struct {
+		desc      string
+		in        string
+		width     int
+		want      string
+		wantWidth int
+	}{{
+		desc:      "Fits",
+		in:        "hello",
+		width:     10,
+		want:      "hello",
+		wantWidth: 5,
+	}, {
+		desc:      "Cut",
+		in:        "hello world",
+		width:     5,
+		want:      "hello",
+		wantWidth: 5,
+	}, {
+		desc:      "WideCharFits",
+		in:        "a世界",
+		width:     5,
+		want:      "a世界",
+		wantWidth: 5,
+	}, {
+		desc:      "WideCharStraddlesLimit",
+		in:        "a世界",
+		width:     4,
+		want:      "a世",
+		wantWidth: 3,
+	}, {
+		desc:      "CJKFilenameAtLimit",
+		in:        "你好世界.txt",
+		width:     8,
+		want:      "你好世界",
+		wantWidth: 8,
+	}, {
+		desc:      "CJKFilenameStraddlesLimit",
+		in:        "你好世界.txt",
+		width:     7,
+		want:      "你好世",
+		wantWidth: 6,
+	}, {
+		desc:      "EmojiAtLimit",
+		in:        "ab😀cd",
+		width:     4,
+		want:      "ab😀",
+		wantWidth: 4,
+	}, {
+		desc:      "EmojiStraddlesLimit",
+		in:        "ab😀cd",
+		width:     3,
+		want:      "ab",
+		wantWidth: 2,
+	}, {
+		desc:      "ZWJSequenceAtLimit",
+		in:        "a👩\u200d💻b", // a, woman technologist, b
+		width:     3,
+		want:      "a👩\u200d💻",
+		wantWidth: 3,
+	}, {
+		desc:      "ZWJSequenceStraddlesLimit",
+		in:        "a👩\u200d💻b",
+		width:     2,
+		want:      "a",
+		wantWidth: 1,
+	}, {
+		desc:      "CombiningMarks",
+		in:        "e\u0301e\u0301e\u0301",
+		width:     2,
+		want:      "e\u0301e\u0301",
+		wantWidth: 2,
+	}, {
+		desc:      "EscapeBeforeCut",
+		in:        "\033[1mbold\033[0m text",
+		width:     6,
+		want:      "\033[1mbold\033[0m t",
+		wantWidth: 6,
+	}, {
+		desc:      "EscapeWithParameters",
+		in:        "\033[31;1mred",
+		width:     2,
+		want:      "\033[31;1mre",
+		wantWidth: 2,
+	}, {
+		desc:      "EscapeAtCut",
+		in:        "bold\033[0m text",
+		width:     4,
+		want:      "bold\033[0m",
+		wantWidth: 4,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, gotWidth := truncate([]byte(tc.in), tc.width)
+			if string(got) != tc.want || gotWidth != tc.wantWidth {
+				t.Errorf("truncate(%q, %d) = %q, %d; want %q, %d", tc.in, tc.width, got, gotWidth, tc.want, tc.wantWidth)
+			}
+		})
+	}
+}
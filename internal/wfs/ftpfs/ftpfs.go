@@ -0,0 +1,368 @@
+// Package ftpfs implements [wfs.FS] using [github.com/jlaffaye/ftp].
+//
+// FTP has no symlinks, permissions, or ownership, so Symlink returns an error
+// and Chmod and Chown return errors wrapping [errors.ErrUnsupported].
+package ftpfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/rhogenson/ccp/internal/wfs"
+	"golang.org/x/term"
+)
+
+var (
+	_ wfs.FS       = (*FS)(nil)
+	_ fs.StatFS    = (*FS)(nil)
+	_ fs.ReadDirFS = (*FS)(nil)
+)
+
+// An FS holds a pool of logged in FTP connections and wraps their operations
+// into the [wfs.FS] interface. An FTP connection can only make one transfer at
+// a time, so each open file holds a connection of its own until it's closed.
+type FS struct {
+	User, Host string
+	addr       string
+	password   string
+
+	mu   sync.Mutex
+	idle []*ftp.ServerConn
+}
+
+// Dial connects and logs in to the FTP server at host, which may include a
+// port. If user is nil, Dial logs in anonymously. If user has no password,
+// Dial prompts for one.
+func Dial(host string, user *url.Userinfo) (*FS, error) {
+	addr := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		addr = net.JoinHostPort(host, "21")
+	}
+	f := &FS{User: "anonymous", Host: host, addr: addr, password: "anonymous"}
+	if user != nil {
+		f.User = user.Username()
+		if password, ok := user.Password(); ok {
+			f.password = password
+		} else {
+			fmt.Fprintf(os.Stderr, "Enter password for %s@%s: ", f.User, host)
+			password, err := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Fprintln(os.Stderr)
+			if err != nil {
+				return nil, err
+			}
+			f.password = string(password)
+		}
+	}
+	conn, err := f.dial()
+	if err != nil {
+		return nil, err
+	}
+	f.idle = append(f.idle, conn)
+	return f, nil
+}
+
+func (f *FS) dial() (*ftp.ServerConn, error) {
+	conn, err := ftp.Dial(f.addr, ftp.DialWithTimeout(30*time.Second))
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Login(f.User, f.password); err != nil {
+		conn.Quit()
+		return nil, fmt.Errorf("ftp login to %s@%s: %w", f.User, f.Host, err)
+	}
+	return conn, nil
+}
+
+// get takes a connection from the pool, or logs in a new one if they're all
+// busy.
+func (f *FS) get() (*ftp.ServerConn, error) {
+	f.mu.Lock()
+	if n := len(f.idle); n > 0 {
+		conn := f.idle[n-1]
+		f.idle = f.idle[:n-1]
+		f.mu.Unlock()
+		return conn, nil
+	}
+	f.mu.Unlock()
+	return f.dial()
+}
+
+// put returns conn to the pool. If the operation using conn failed with
+// anything other than an error reply from the server, the connection may be in
+// an unknown state, so it's closed instead.
+func (f *FS) put(conn *ftp.ServerConn, err error) {
+	if err != nil && !errors.As(err, new(*textproto.Error)) &&
+		!errors.Is(err, fs.ErrNotExist) && !errors.Is(err, errors.ErrUnsupported) {
+		conn.Quit()
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.idle = append(f.idle, conn)
+}
+
+// do runs op on a connection from the pool.
+func (f *FS) do(op func(conn *ftp.ServerConn) error) error {
+	conn, err := f.get()
+	if err != nil {
+		return err
+	}
+	err = op(conn)
+	f.put(conn, err)
+	return err
+}
+
+// Close logs out of the server.
+func (f *FS) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var err error
+	for _, conn := range f.idle {
+		if err1 := conn.Quit(); err == nil {
+			err = err1
+		}
+	}
+	f.idle = nil
+	return err
+}
+
+func (f *FS) err(op, name string, err error) error {
+	return fmt.Errorf("%s %q: %w", op, "ftp://"+f.User+"@"+f.Host+"/"+name, err)
+}
+
+type fileInfo struct {
+	e *ftp.Entry
+}
+
+func (fi fileInfo) Name() string { return path.Base(fi.e.Name) }
+func (fi fileInfo) Size() int64  { return int64(fi.e.Size) }
+func (fi fileInfo) Mode() fs.FileMode {
+	switch fi.e.Type {
+	case ftp.EntryTypeFolder:
+		return fs.ModeDir | 0755
+	case ftp.EntryTypeLink:
+		return fs.ModeSymlink | 0777
+	}
+	return 0644
+}
+func (fi fileInfo) ModTime() time.Time { return fi.e.Time }
+func (fi fileInfo) IsDir() bool        { return fi.e.Type == ftp.EntryTypeFolder }
+func (fi fileInfo) Sys() any           { return fi.e }
+
+// wfs.FS implementation:
+
+func (f *FS) Open(name string) (fs.File, error) {
+	stat, err := f.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if stat.IsDir() {
+		return &dir{fsys: f, name: name, info: stat}, nil
+	}
+	conn, err := f.get()
+	if err != nil {
+		return nil, f.err("open", name, err)
+	}
+	resp, err := conn.Retr(name)
+	if err != nil {
+		f.put(conn, err)
+		return nil, f.err("open", name, err)
+	}
+	return &file{resp, stat, f, conn}, nil
+}
+
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var list []*ftp.Entry
+	if err := f.do(func(conn *ftp.ServerConn) error {
+		var err error
+		list, err = conn.List(name)
+		return err
+	}); err != nil {
+		return nil, f.err("readdir", name, err)
+	}
+	entries := make([]fs.DirEntry, 0, len(list))
+	for _, e := range list {
+		if e.Name == "." || e.Name == ".." {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(fileInfo{e}))
+	}
+	return entries, nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	name = path.Clean(name)
+	if name == "." || name == "/" {
+		return fileInfo{&ftp.Entry{Name: name, Type: ftp.EntryTypeFolder}}, nil
+	}
+	var e *ftp.Entry
+	if err := f.do(func(conn *ftp.ServerConn) error {
+		var err error
+		// MLST isn't supported everywhere, so fall back to
+		// listing the parent directory.
+		if e, err = conn.GetEntry(name); err == nil {
+			return nil
+		}
+		list, err := conn.List(path.Dir(name))
+		var protoErr *textproto.Error
+		if errors.As(err, &protoErr) && protoErr.Code == ftp.StatusFileUnavailable {
+			return fs.ErrNotExist
+		} else if err != nil {
+			return err
+		}
+		for _, entry := range list {
+			if entry.Name == path.Base(name) {
+				e = entry
+				return nil
+			}
+		}
+		return fs.ErrNotExist
+	}); err != nil {
+		return nil, f.err("stat", name, err)
+	}
+	return fileInfo{e}, nil
+}
+
+func (f *FS) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	conn, err := f.get()
+	if err != nil {
+		return nil, f.err("open", name, err)
+	}
+	// Stor reads the file contents from a reader, so feed it through a
+	// pipe.
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := conn.Stor(name, pr)
+		pr.CloseWithError(err)
+		f.put(conn, err)
+		done <- err
+	}()
+	return &writer{pw, done, f, name}, nil
+}
+
+func (f *FS) Remove(name string) error {
+	if err := f.do(func(conn *ftp.ServerConn) error {
+		err := conn.Delete(name)
+		if err != nil && conn.RemoveDir(name) == nil {
+			return nil
+		}
+		return err
+	}); err != nil {
+		return f.err("remove", name, err)
+	}
+	return nil
+}
+
+func (f *FS) Mkdir(name string) error {
+	if err := f.do(func(conn *ftp.ServerConn) error {
+		return conn.MakeDir(name)
+	}); err != nil {
+		return f.err("mkdir", name, err)
+	}
+	return nil
+}
+
+func (f *FS) Symlink(oldname, newname string) error {
+	return f.err("symlink", newname, fs.ErrInvalid)
+}
+
+func (f *FS) Chmod(name string, mode fs.FileMode) error {
+	return f.err("chmod", name, errors.ErrUnsupported)
+}
+
+func (f *FS) Chtimes(name string, atime, mtime time.Time) error {
+	if err := f.do(func(conn *ftp.ServerConn) error {
+		if !conn.IsSetTimeSupported() {
+			return errors.ErrUnsupported
+		}
+		return conn.SetTime(name, mtime)
+	}); err != nil {
+		return f.err("chtimes", name, err)
+	}
+	return nil
+}
+
+func (f *FS) Chown(name string, uid, gid int) error {
+	return f.err("chown", name, errors.ErrUnsupported)
+}
+
+// A file is a regular file being downloaded.
+type file struct {
+	*ftp.Response
+	info fs.FileInfo
+	fsys *FS
+	conn *ftp.ServerConn
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Close() error {
+	err := f.Response.Close()
+	f.fsys.put(f.conn, err)
+	return err
+}
+
+type dir struct {
+	fsys    *FS
+	name    string
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+func (d *dir) Close() error { return nil }
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.read = entries, true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// A writer is a regular file being uploaded.
+type writer struct {
+	*io.PipeWriter
+	done chan error
+	fsys *FS
+	name string
+}
+
+func (w *writer) Close() error {
+	w.PipeWriter.Close()
+	if err := <-w.done; err != nil {
+		return w.fsys.err("close", w.name, err)
+	}
+	return nil
+}
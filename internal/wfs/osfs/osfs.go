@@ -5,6 +5,8 @@ import (
 	"io"
 	"io/fs"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/rhogenson/ccp/internal/wfs"
 )
@@ -12,8 +14,14 @@ import (
 var (
 	_ wfs.FS          = FS{}
 	_ wfs.MkdirModeFS = FS{}
+	_ wfs.AppendFS    = FS{}
+	_ wfs.LinkFS      = FS{}
+	_ wfs.RenameFS    = FS{}
+	_ wfs.SyncDirFS   = FS{}
 	_ wfs.ReadLinkFS  = FS{}
 	_ fs.StatFS       = FS{}
+	_ fs.GlobFS       = FS{}
+	_ fs.SubFS        = FS{}
 )
 
 // An FS is a [wfs.FS] backed by the local filesystem.
@@ -27,6 +35,16 @@ func (FS) Stat(name string) (fs.FileInfo, error) {
 	return os.Stat(name)
 }
 
+func (FS) Glob(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+// Sub returns a view of the directory dir, which may be relative to the working
+// directory or absolute.
+func (fsys FS) Sub(dir string) (fs.FS, error) {
+	return wfs.Sub(fsys, dir), nil
+}
+
 func (FS) Lstat(name string) (fs.FileInfo, error) {
 	return os.Lstat(name)
 }
@@ -39,6 +57,10 @@ func (FS) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
 	return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
 }
 
+func (FS) OpenAppend(name string) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+}
+
 func (FS) Remove(name string) error {
 	return os.Remove(name)
 }
@@ -55,6 +77,31 @@ func (FS) Symlink(oldname, newname string) error {
 	return os.Symlink(oldname, newname)
 }
 
+func (FS) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (FS) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+func (FS) SyncDir(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
 func (FS) Chmod(name string, mode fs.FileMode) error {
 	return os.Chmod(name, mode)
 }
+
+func (FS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (FS) Chown(name string, uid, gid int) error {
+	return os.Lchown(name, uid, gid)
+}
@@ -0,0 +1,95 @@
+package osfs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+
+	"github.com/rhogenson/ccp/internal/wfs"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	_ wfs.XattrFS = FS{}
+	_ wfs.ACLFS   = FS{}
+)
+
+func (FS) ListXattr(name string) ([]string, error) {
+	for {
+		size, err := unix.Llistxattr(name, nil)
+		if err != nil {
+			return nil, &fs.PathError{Op: "listxattr", Path: name, Err: err}
+		}
+		buf := make([]byte, size)
+		n, err := unix.Llistxattr(name, buf)
+		if errors.Is(err, unix.ERANGE) {
+			// The list grew since we asked for its size.
+			continue
+		}
+		if err != nil {
+			return nil, &fs.PathError{Op: "listxattr", Path: name, Err: err}
+		}
+		var attrs []string
+		for attr := range bytes.SplitSeq(buf[:n], []byte{0}) {
+			if len(attr) > 0 {
+				attrs = append(attrs, string(attr))
+			}
+		}
+		return attrs, nil
+	}
+}
+
+func (FS) GetXattr(name, attr string) ([]byte, error) {
+	for {
+		size, err := unix.Lgetxattr(name, attr, nil)
+		if err != nil {
+			return nil, &fs.PathError{Op: "getxattr", Path: name, Err: err}
+		}
+		buf := make([]byte, size)
+		n, err := unix.Lgetxattr(name, attr, buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, &fs.PathError{Op: "getxattr", Path: name, Err: err}
+		}
+		return buf[:n], nil
+	}
+}
+
+func (FS) SetXattr(name, attr string, data []byte) error {
+	if err := unix.Lsetxattr(name, attr, data, 0); err != nil {
+		return &fs.PathError{Op: "setxattr", Path: name, Err: err}
+	}
+	return nil
+}
+
+// aclXattrs are the extended attributes that Linux keeps ACLs in.
+var aclXattrs = map[wfs.ACLType]string{
+	wfs.AccessACL:  "system.posix_acl_access",
+	wfs.DefaultACL: "system.posix_acl_default",
+}
+
+func (fsys FS) GetACL(name string, typ wfs.ACLType) ([]byte, error) {
+	acl, err := fsys.GetXattr(name, aclXattrs[typ])
+	// A file system without ACLs has none to copy.
+	if errors.Is(err, unix.ENODATA) || errors.Is(err, unix.EOPNOTSUPP) {
+		return nil, nil
+	}
+	return acl, err
+}
+
+func (fsys FS) SetACL(name string, typ wfs.ACLType, acl []byte) error {
+	if acl != nil {
+		return fsys.SetXattr(name, aclXattrs[typ], acl)
+	}
+	err := unix.Lremovexattr(name, aclXattrs[typ])
+	if errors.Is(err, unix.ENODATA) || errors.Is(err, unix.EOPNOTSUPP) {
+		// There was nothing to remove.
+		return nil
+	}
+	if err != nil {
+		return &fs.PathError{Op: "removexattr", Path: name, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+//go:build unix
+
+package osfs
+
+import (
+	"io/fs"
+	"time"
+
+	"github.com/rhogenson/ccp/internal/wfs"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	_ wfs.LchtimesFS = FS{}
+	_ wfs.MknodFS    = FS{}
+)
+
+func (FS) Lchtimes(name string, atime, mtime time.Time) error {
+	ts := []unix.Timespec{unix.NsecToTimespec(atime.UnixNano()), unix.NsecToTimespec(mtime.UnixNano())}
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, name, ts, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return &fs.PathError{Op: "lchtimes", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (FS) Mknod(name string, mode fs.FileMode, dev uint64) error {
+	var typ uint32
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		typ = unix.S_IFIFO
+	case mode&fs.ModeSocket != 0:
+		typ = unix.S_IFSOCK
+	case mode&fs.ModeCharDevice != 0:
+		typ = unix.S_IFCHR
+	case mode&fs.ModeDevice != 0:
+		typ = unix.S_IFBLK
+	default:
+		return &fs.PathError{Op: "mknod", Path: name, Err: fs.ErrInvalid}
+	}
+	if err := unix.Mknod(name, typ|uint32(mode.Perm()), int(dev)); err != nil {
+		return &fs.PathError{Op: "mknod", Path: name, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package sftpfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+// DialCommand establishes a new SFTP connection to target, user@host or host,
+// by running command with its standard input and output as the SFTP session,
+// like rsync -e. That lets the system's ssh connect, with whatever keys,
+// ciphers, and config it supports. command is typically ssh with any options
+// needed, followed by the target and "-s sftp". Each session, including any
+// started for cfg.Sessions or to recover from errors, runs command again. Of
+// cfg, only Retries, Sessions, MaxPacket, MaxRequests, and Logger are used.
+func DialCommand(target string, command []string, cfg Config) (*FS, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("no command to connect to %s", target)
+	}
+	user, host, ok := strings.Cut(target, "@")
+	if !ok {
+		host = target
+		if user = configValue(host, "User"); user == "" {
+			user = os.Getenv("USER")
+		}
+	}
+	log := cfg.logger()
+	log.Debug("running", "host", host, "command", command)
+	sftpOptions := clientOptions(cfg)
+	sftpConn, err := commandSession(command, sftpOptions)
+	if err != nil {
+		return nil, fmt.Errorf("sftp session with %s using %s: %w", target, command[0], err)
+	}
+	log.Info("connected", "host", host, "user", user, "command", command[0])
+	conns := make([]*sftp.Client, max(cfg.Sessions, 1))
+	conns[0] = sftpConn
+	return &FS{
+		User:        user,
+		Host:        strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"),
+		retries:     cfg.Retries,
+		log:         log,
+		command:     command,
+		sftpOptions: sftpOptions,
+		conns:       conns,
+	}, nil
+}
+
+// commandSession starts command and returns an SFTP session over its standard
+// input and output. Closing the session waits for the command to exit.
+func commandSession(command []string, sftpOptions []sftp.ClientOption) (*sftp.Client, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	// Let the command prompt for passwords and report errors.
+	cmd.Stderr = os.Stderr
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	conn, err := sftp.NewClientPipe(r, &waitCloser{w, cmd}, sftpOptions...)
+	if err != nil {
+		w.Close()
+		cmd.Wait()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// A waitCloser is the standard input of a command, which waits for the command
+// to exit once it's closed.
+type waitCloser struct {
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+func (w *waitCloser) Close() error {
+	err := w.WriteCloser.Close()
+	// The command exits with an error when its connection is closed
+	// out from under it, which isn't interesting.
+	w.cmd.Wait()
+	return err
+}
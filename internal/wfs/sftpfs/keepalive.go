@@ -0,0 +1,56 @@
+package sftpfs
+
+import (
+	"log/slog"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// serverAliveCountMax is how many keepalive intervals to wait for the server
+// to answer before giving up on the connection, like ssh's
+// ServerAliveCountMax.
+const serverAliveCountMax = 3
+
+// keepAlive sends a keepalive request over conn every interval until conn is
+// closed, so that NAT and firewall state for the connection doesn't expire
+// while it's idle. If the server stops answering, keepAlive closes conn; the
+// next operation then fails and is retried on a new connection.
+func keepAlive(conn *ssh.Client, interval time.Duration, log *slog.Logger) {
+	if interval <= 0 {
+		return
+	}
+	closed := make(chan struct{})
+	go func() {
+		conn.Wait()
+		close(closed)
+	}()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closed:
+				return
+			case <-ticker.C:
+			}
+			reply := make(chan error, 1)
+			go func() {
+				_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+				reply <- err
+			}()
+			select {
+			case <-closed:
+				return
+			case err := <-reply:
+				if err != nil {
+					return
+				}
+			case <-time.After(serverAliveCountMax * interval):
+				log.Warn("server stopped answering keepalives", "addr", conn.RemoteAddr())
+				conn.Close()
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,137 @@
+package sftpfs
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// options lists the ssh_config(5) options that can be given in
+// Config.Options, by their canonical names, with a function that checks a
+// value for each.
+var options = map[string]func(string) error{
+	"User":                  nonEmpty,
+	"HostName":              nonEmpty,
+	"Port":                  checkPort,
+	"IdentityFile":          nonEmpty,
+	"IdentitiesOnly":        yesNo,
+	"StrictHostKeyChecking": checkHostKeyChecking,
+	"UserKnownHostsFile":    nonEmpty,
+	"ServerAliveInterval":   checkSeconds,
+	"ConnectTimeout":        checkSeconds,
+	"Ciphers":               checkAlgorithms,
+	"MACs":                  checkAlgorithms,
+	"KexAlgorithms":         checkAlgorithms,
+	"HostKeyAlgorithms":     checkAlgorithms,
+}
+
+// ParseOption parses an SSH option in the form ssh -o accepts, "Key=Value" or
+// "Key Value", returning the canonical name of the key. It returns an error if
+// the option is malformed or isn't one that [Dial] supports.
+func ParseOption(s string) (key, value string, err error) {
+	key, value, ok := strings.Cut(strings.TrimSpace(s), "=")
+	if !ok {
+		key, value, ok = strings.Cut(key, " ")
+	}
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("SSH option %q is not in the form Key=Value", s)
+	}
+	for name := range options {
+		if strings.EqualFold(name, key) {
+			key = name
+			break
+		}
+	}
+	if err := checkOption(key, value); err != nil {
+		return "", "", err
+	}
+	return key, value, nil
+}
+
+// checkOption returns an error if key isn't a supported option or value isn't
+// valid for it.
+func checkOption(key, value string) error {
+	check, ok := options[key]
+	if !ok {
+		return fmt.Errorf("unsupported SSH option %s", key)
+	}
+	if err := check(value); err != nil {
+		return fmt.Errorf("SSH option %s=%s: %w", key, value, err)
+	}
+	return nil
+}
+
+func nonEmpty(value string) error {
+	if value == "" {
+		return errors.New("missing value")
+	}
+	return nil
+}
+
+func checkPort(value string) error {
+	if p, err := strconv.Atoi(value); err != nil || p < 1 || p > 65535 {
+		return errors.New("not a port number")
+	}
+	return nil
+}
+
+func yesNo(value string) error {
+	if value != "yes" && value != "no" {
+		return errors.New("must be yes or no")
+	}
+	return nil
+}
+
+func checkHostKeyChecking(value string) error {
+	if _, ok := parseHostKeyChecking(value); !ok {
+		return errors.New("must be yes, no, ask, or accept-new")
+	}
+	return nil
+}
+
+func parseHostKeyChecking(value string) (HostKeyChecking, bool) {
+	switch value {
+	case "yes":
+		return Strict, true
+	case "ask":
+		return Ask, true
+	case "no", "off", "accept-new":
+		return AcceptNew, true
+	}
+	return 0, false
+}
+
+func checkSeconds(value string) error {
+	_, err := parseSeconds(value)
+	return err
+}
+
+// parseSeconds parses a time in seconds, as used by ServerAliveInterval and
+// ConnectTimeout.
+func parseSeconds(value string) (time.Duration, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, errors.New("not a number of seconds")
+	}
+	return time.Duration(n) * time.Second, nil
+}
+
+func checkAlgorithms(value string) error {
+	if value == "" {
+		return errors.New("missing value")
+	}
+	if strings.ContainsAny(value[:1], "+-^") {
+		// Modifying the default list would mean keeping track of what
+		// golang.org/x/crypto/ssh's defaults are.
+		return errors.New("only a plain list of algorithms is supported")
+	}
+	return nil
+}
+
+// algorithms splits a comma-separated list of algorithms.
+func algorithms(value string) []string {
+	return strings.Split(value, ",")
+}
@@ -0,0 +1,186 @@
+package sftpfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// transient reports whether err looks like it was caused by a network problem
+// that might go away if the operation is retried.
+func transient(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, sftp.ErrSSHFxConnectionLost) ||
+		errors.Is(err, sftp.ErrSSHFxNoConnection) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ENETUNREACH) ||
+		errors.Is(err, syscall.EHOSTUNREACH) ||
+		errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// client returns the next SFTP session from the pool, round-robin. Sessions
+// are opened the first time they're used.
+func (f *FS) client() *sftp.Client {
+	i := int(f.next.Add(1) % uint32(len(f.conns)))
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conns[i] == nil {
+		conn, err := f.newSession()
+		if err != nil {
+			// The first session always exists. If it's broken
+			// too, the caller will reconnect it.
+			return f.conns[0]
+		}
+		f.conns[i] = conn
+	}
+	return f.conns[i]
+}
+
+// newSession opens a new SFTP session, over the SSH connection or by running
+// the command given to DialCommand.
+func (f *FS) newSession() (*sftp.Client, error) {
+	if f.command != nil {
+		return commandSession(f.command, f.sftpOptions)
+	}
+	return sftp.NewClient(f.sshConn, f.sftpOptions...)
+}
+
+// reconnect replaces the SFTP session old, which has failed, with a new one.
+// If the SSH connection is still alive, the new session runs over it;
+// otherwise reconnect dials a new SSH connection. A session from DialCommand is
+// replaced by running the command again. If another goroutine has already
+// replaced old, reconnect returns a session from the pool.
+func (f *FS) reconnect(old *sftp.Client) (*sftp.Client, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := slices.Index(f.conns, old)
+	if i < 0 {
+		return f.conns[0], nil
+	}
+	conn, err := f.newSession()
+	if err != nil && f.command != nil {
+		// Each session already ran its own connection.
+		return nil, err
+	}
+	if err != nil {
+		f.log.Info("reconnecting", "host", f.Host, "addr", f.addr, "err", err)
+		sshConn, err := ssh.Dial("tcp", f.addr, f.clientConfig)
+		if err != nil {
+			return nil, err
+		}
+		if conn, err = sftp.NewClient(sshConn, f.sftpOptions...); err != nil {
+			sshConn.Close()
+			return nil, err
+		}
+		keepAlive(sshConn, f.aliveInterval, f.log)
+		f.sshConn.Close()
+		f.sshConn = sshConn
+	}
+	f.log.Debug("opened new session", "host", f.Host)
+	old.Close()
+	f.conns[i] = conn
+	return conn, nil
+}
+
+// retry calls op with the current SFTP session. If op fails with a transient
+// error, retry waits with exponential backoff, reconnects, and tries again, up
+// to f.retries times.
+func (f *FS) retry(op func(*sftp.Client) error) error {
+	conn := f.client()
+	err := op(conn)
+	for attempt := 0; err != nil && transient(err) && attempt < f.retries; attempt++ {
+		f.log.Warn("retrying after network error", "host", f.Host, "attempt", attempt+1, "err", err)
+		time.Sleep(time.Second << attempt)
+		var reconnectErr error
+		if conn, reconnectErr = f.reconnect(conn); reconnectErr != nil {
+			err = reconnectErr
+			continue
+		}
+		err = op(conn)
+	}
+	return err
+}
+
+// A file is an SFTP file opened for reading. If a read fails with a transient
+// error, the file is reopened and the read continues where it left off.
+type file struct {
+	fsys *FS
+	name string
+	conn *sftp.Client // The session f was opened with
+	f    *sftp.File
+	off  int64
+}
+
+var _ io.ReadSeekCloser = (*file)(nil)
+
+func (r *file) Read(p []byte) (int, error) {
+	for attempt := 0; ; attempt++ {
+		n, err := r.f.Read(p)
+		r.off += int64(n)
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		if !transient(err) || attempt >= r.fsys.retries {
+			return n, r.fsys.err("read", r.name, err)
+		}
+		if n > 0 {
+			// The next read will fail again and reconnect.
+			return n, nil
+		}
+		r.fsys.log.Warn("retrying read after network error", "host", r.fsys.Host, "path", r.name, "attempt", attempt+1, "err", err)
+		time.Sleep(time.Second << attempt)
+		// If reopening fails transiently, the next read will fail
+		// too and we'll try again.
+		if err := r.reopen(); err != nil && !transient(err) {
+			return 0, r.fsys.err("open", r.name, err)
+		}
+	}
+}
+
+// reopen reopens the file on a new SFTP session at the current offset.
+func (r *file) reopen() error {
+	conn, err := r.fsys.reconnect(r.conn)
+	if err != nil {
+		return err
+	}
+	f, err := conn.Open(r.name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(r.off, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	r.f.Close()
+	r.conn, r.f = conn, f
+	return nil
+}
+
+func (r *file) Seek(offset int64, whence int) (int64, error) {
+	off, err := r.f.Seek(offset, whence)
+	if err != nil {
+		return off, err
+	}
+	r.off = off
+	return off, nil
+}
+
+func (r *file) Stat() (fs.FileInfo, error) {
+	return r.f.Stat()
+}
+
+func (r *file) Close() error {
+	return r.f.Close()
+}
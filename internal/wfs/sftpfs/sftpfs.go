@@ -2,16 +2,24 @@
 package sftpfs
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"log/slog"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/kevinburke/ssh_config"
 	"github.com/pkg/sftp"
 	"github.com/rhogenson/ccp/internal/wfs"
 	"golang.org/x/crypto/ssh"
@@ -23,18 +31,125 @@ import (
 var (
 	_ wfs.FS         = (*FS)(nil)
 	_ wfs.ReadLinkFS = (*FS)(nil)
+	_ wfs.AppendFS   = (*FS)(nil)
+	_ wfs.LinkFS     = (*FS)(nil)
+	_ wfs.RenameFS   = (*FS)(nil)
 	_ fs.StatFS      = (*FS)(nil)
 	_ fs.ReadDirFS   = (*FS)(nil)
+	_ fs.GlobFS      = (*FS)(nil)
+	_ fs.SubFS       = (*FS)(nil)
 )
 
 // An FS holds an SFTP connection and wraps its operations into the
 // [wfs.FS] interface.
 type FS struct {
 	User, Host string
-	conn       *sftp.Client
-	sshConn    *ssh.Client
+	retries    int
+	log        *slog.Logger // Discards everything if Config.Logger is nil
+	// The parameters used to dial the SSH connection, kept so it can be
+	// reestablished if it drops.
+	addr          string
+	clientConfig  *ssh.ClientConfig
+	aliveInterval time.Duration
+	sftpOptions   []sftp.ClientOption
+	// command, if set, is run for each new session instead of using
+	// sshConn. See DialCommand.
+	command []string
+
+	next    atomic.Uint32 // Used to pick sessions from conns round-robin
+	mu      sync.Mutex
+	conns   []*sftp.Client // A pool of SFTP sessions; nil until first used
+	sshConn *ssh.Client
+}
+
+// Config holds optional settings for [Dial]. The zero value is a valid
+// configuration.
+type Config struct {
+	// Port is the port to connect to. If Port is 0, the port from
+	// ~/.ssh/config is used, or else the default SSH port 22.
+	Port int
+	// Retries is the number of times to retry an operation that fails
+	// with what looks like a transient network error. Before each retry,
+	// the SFTP session is reestablished.
+	Retries int
+	// Sessions is the number of SFTP sessions to open over the SSH
+	// connection. Operations are spread across the sessions, which helps
+	// throughput on high latency links and with servers that handle each
+	// session in a single thread. If Sessions is 0, one session is used.
+	Sessions int
+	// KnownHosts is the known_hosts file used to verify host keys. If
+	// KnownHosts is "", ~/.ssh/known_hosts is used.
+	KnownHosts string
+	// HostKeyChecking controls what happens when the host's key isn't in
+	// KnownHosts.
+	HostKeyChecking HostKeyChecking
+	// IdentityFiles are private key files to authenticate with, like
+	// ssh -i. If any are given, only they and the IdentityFile settings
+	// from ~/.ssh/config are tried: the ssh agent isn't consulted and
+	// ~/.ssh isn't searched for keys, as with the IdentitiesOnly option.
+	IdentityFiles []string
+	// ServerAliveInterval is how often to send a keepalive request to the
+	// server, to stop idle connections being dropped by firewalls. If the
+	// server doesn't answer several in a row, the connection is closed. If
+	// ServerAliveInterval is 0, no keepalives are sent.
+	ServerAliveInterval time.Duration
+	// ConnectTimeout is how long to wait for the TCP connection to the
+	// server to be established, including when reconnecting. If
+	// ConnectTimeout is 0, the operating system's timeout applies.
+	ConnectTimeout time.Duration
+	// MaxPacket is the largest amount of data, in bytes, to read or write
+	// in a single SFTP request. Larger packets need fewer round trips, but
+	// servers are only required to accept 32768 bytes, and some drop the
+	// connection when sent more. If MaxPacket is 0, 32768 is used.
+	MaxPacket int
+	// MaxRequests is the number of SFTP requests to have in flight at once
+	// when reading or writing a file. More requests keep a high latency
+	// link busy, at the cost of up to MaxRequests*MaxPacket bytes of
+	// buffers per file. If MaxRequests is 0, 64 is used.
+	MaxRequests int
+	// Options holds ssh_config(5) options, like those given to ssh -o,
+	// keyed by the canonical names returned by [ParseOption]. They take
+	// precedence over ~/.ssh/config and over the corresponding fields of
+	// Config. Ciphers, MACs, KexAlgorithms, and HostKeyAlgorithms are only
+	// read from here, not from ~/.ssh/config.
+	Options map[string]string
+	// Logger, if set, receives debugging logs of connecting, reconnecting,
+	// and retrying operations after network errors.
+	Logger *slog.Logger
+}
+
+// logger returns cfg.Logger, or a logger that discards everything if it's nil.
+func (cfg *Config) logger() *slog.Logger {
+	if cfg.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return cfg.Logger
 }
 
+// option returns the value of key from cfg.Options, or else from the Host
+// entries in ~/.ssh/config that match alias, or "" if there is none.
+func (cfg *Config) option(alias, key string) string {
+	if v, ok := cfg.Options[key]; ok {
+		return v
+	}
+	return configValue(alias, key)
+}
+
+// HostKeyChecking says what [Dial] does with a host key that isn't in the
+// known_hosts file. A key that doesn't match the one in known_hosts is always
+// rejected.
+type HostKeyChecking int
+
+const (
+	// AcceptNew adds unknown host keys to known_hosts without asking.
+	AcceptNew HostKeyChecking = iota
+	// Strict rejects unknown host keys.
+	Strict
+	// Ask prompts on the terminal whether to trust an unknown host key,
+	// and adds it to known_hosts if the user says yes.
+	Ask
+)
+
 var sshAgent = sync.OnceValue(func() agent.ExtendedAgent {
 	socket := os.Getenv("SSH_AUTH_SOCK")
 	if socket == "" {
@@ -47,49 +162,181 @@ var sshAgent = sync.OnceValue(func() agent.ExtendedAgent {
 	return agent.NewClient(conn)
 })
 
+var (
+	// promptMu serializes prompts on the terminal, since several hosts
+	// may be dialed at once.
+	promptMu sync.Mutex
+	// unlockedKeys holds the password-protected keys the user has entered
+	// the password for, by file name, so they're only asked once. It's
+	// guarded by promptMu.
+	unlockedKeys = make(map[string]ssh.Signer)
+	// skipped holds the security key files the user has been told can't
+	// be used without the agent. It's guarded by promptMu.
+	skipped = make(map[string]bool)
+	// knownHostsMu serializes additions to known_hosts files, for the
+	// same reason.
+	knownHostsMu sync.Mutex
+)
+
+var sshConfig = sync.OnceValue(func() *ssh_config.Config {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	f, err := os.Open(filepath.Join(home, ".ssh/config"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	cfg, err := ssh_config.Decode(f)
+	if err != nil {
+		return nil
+	}
+	return cfg
+})
+
+// configValues returns the values of key from the Host entries in
+// ~/.ssh/config that match alias.
+func configValues(alias, key string) []string {
+	cfg := sshConfig()
+	if cfg == nil {
+		return nil
+	}
+	values, _ := cfg.GetAll(alias, key)
+	return values
+}
+
+// configValue returns the first value of key from the Host entries in
+// ~/.ssh/config that match alias, or "" if there is none.
+func configValue(alias, key string) string {
+	values := configValues(alias, key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// expandHome expands a leading ~/ in path to the user's home directory. It
+// returns an error if there's one to expand but the home directory isn't
+// known, as when $HOME is unset.
+func expandHome(path string) (string, error) {
+	rest, ok := strings.CutPrefix(path, "~/")
+	if !ok {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("can't expand %s: %w", path, err)
+	}
+	return filepath.Join(home, rest), nil
+}
+
+// loadCert returns the OpenSSH certificate for the private key in keyFile,
+// which is in keyFile-cert.pub like ssh expects, or nil if there isn't one.
+func loadCert(keyFile string) *ssh.Certificate {
+	data, err := os.ReadFile(keyFile + "-cert.pub")
+	if err != nil {
+		return nil
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil
+	}
+	cert, _ := pub.(*ssh.Certificate)
+	return cert
+}
+
+// withCert returns signer, preceded by a signer for the certificate cert if it
+// isn't nil.
+func withCert(signer ssh.Signer, cert *ssh.Certificate) []ssh.Signer {
+	if cert == nil {
+		return []ssh.Signer{signer}
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return []ssh.Signer{signer}
+	}
+	return []ssh.Signer{certSigner, signer}
+}
+
 // sshKeys returns the available ssh public keys. If an ssh agent can be
-// contacted with $SSH_AUTH_SOCK, sshKeys uses the keys from the agent if
-// possible. Otherwise sshKeys loads keys from ~/.ssh. If there are any password
-// protected keys, sshKeys may prompt the user for the password (although it
-// will do so at most once).
+// contacted with $SSH_AUTH_SOCK and identitiesOnly is false, sshKeys uses the
+// keys from the agent if possible. Otherwise sshKeys loads keys from
+// identityFiles, or from every file in ~/.ssh if identityFiles is empty. If
+// there are any password protected keys, sshKeys may prompt the user for the
+// password (although it will do so at most once). A key with a certificate
+// next to it, like id_ed25519-cert.pub, is offered with the certificate first.
 //
-// If a password-protected key is loaded from ~/.ssh, it will be added to the
+// If a password-protected key is loaded from disk, it will be added to the
 // ssh agent if possible.
-func sshKeys() ([]ssh.Signer, error) {
+//
+// The private half of a FIDO security key, like a YubiKey holding an
+// sk-ssh-ed25519 key, never leaves the device, so its file can't be loaded.
+// Such a file is recognized by its .pub file, and the matching key is used
+// from the agent instead, even if identitiesOnly is set. If the agent doesn't
+// have it, sshKeys says so on stderr, since the key must be added with ssh-add
+// to be used.
+func sshKeys(identityFiles []string, identitiesOnly bool) ([]ssh.Signer, error) {
 	sshAgent := sshAgent()
+	skAgent := sshAgent
+	if identitiesOnly {
+		sshAgent = nil
+	}
 	if sshAgent != nil {
 		if signers, err := sshAgent.Signers(); err == nil && len(signers) > 0 {
 			return signers, nil
 		}
 	}
-	sshDir := filepath.Join(os.Getenv("HOME"), ".ssh")
-	sshFiles, err := os.ReadDir(sshDir)
-	if len(sshFiles) == 0 {
-		return nil, err
+	if len(identityFiles) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("can't find SSH keys in ~/.ssh: %w", err)
+		}
+		sshDir := filepath.Join(home, ".ssh")
+		sshFiles, err := os.ReadDir(sshDir)
+		if len(sshFiles) == 0 {
+			return nil, err
+		}
+		for _, f := range sshFiles {
+			if f.Name() == "known_hosts" || f.Name() == "config" || strings.HasSuffix(f.Name(), ".pub") {
+				continue
+			}
+			identityFiles = append(identityFiles, filepath.Join(sshDir, f.Name()))
+		}
 	}
 	var keys []ssh.Signer
 	var passwordProtectedKey []byte
 	var passwordProtectedKeyFile string
-	for _, f := range sshFiles {
-		if f.Name() == "known_hosts" || strings.HasSuffix(f.Name(), ".pub") {
-			continue
-		}
-		fileName := filepath.Join(sshDir, f.Name())
+	for _, fileName := range identityFiles {
 		keyBytes, err := os.ReadFile(fileName)
 		if err != nil {
 			continue
 		}
 		key, err := ssh.ParsePrivateKey(keyBytes)
 		if err != nil {
+			if pub := securityKey(fileName); pub != nil {
+				if signer := agentSigner(skAgent, pub); signer != nil {
+					keys = append(keys, withCert(signer, loadCert(fileName))...)
+				} else {
+					missingSecurityKey(fileName)
+				}
+				continue
+			}
 			if passwordProtectedKey == nil && errors.As(err, new(*ssh.PassphraseMissingError)) {
 				passwordProtectedKey = keyBytes
 				passwordProtectedKeyFile = fileName
 			}
 			continue
 		}
-		keys = append(keys, key)
+		keys = append(keys, withCert(key, loadCert(fileName))...)
 	}
 	if len(keys) == 0 && passwordProtectedKey != nil {
+		promptMu.Lock()
+		defer promptMu.Unlock()
+		cert := loadCert(passwordProtectedKeyFile)
+		if signer, ok := unlockedKeys[passwordProtectedKeyFile]; ok {
+			return withCert(signer, cert), nil
+		}
 		fmt.Fprintf(os.Stderr, "Enter password for %s: ", passwordProtectedKeyFile)
 		for i := range 3 {
 			if i > 0 {
@@ -106,20 +353,77 @@ func sshKeys() ([]ssh.Signer, error) {
 			}
 			if sshAgent != nil {
 				sshAgent.Add(agent.AddedKey{PrivateKey: key})
+				if cert != nil {
+					sshAgent.Add(agent.AddedKey{PrivateKey: key, Certificate: cert})
+				}
 			}
 			signer, err := ssh.NewSignerFromKey(key)
 			if err != nil {
 				return nil, err
 			}
-			return []ssh.Signer{signer}, nil
+			unlockedKeys[passwordProtectedKeyFile] = signer
+			return withCert(signer, cert), nil
 		}
 		return nil, errors.New("user couldn't remember her password")
 	}
 	return keys, nil
 }
 
-func appendToKnownHosts(hostname string, key ssh.PublicKey) error {
-	f, err := os.OpenFile(filepath.Join(os.Getenv("HOME"), ".ssh/known_hosts"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+// securityKey returns the public key of the FIDO security key whose key file
+// is keyFile, or nil if it isn't one.
+func securityKey(keyFile string) ssh.PublicKey {
+	data, err := os.ReadFile(keyFile + ".pub")
+	if err != nil {
+		return nil
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil || !strings.HasPrefix(pub.Type(), "sk-") {
+		return nil
+	}
+	return pub
+}
+
+// agentSigner returns the agent's signer for pub, or nil if it doesn't have
+// one.
+func agentSigner(sshAgent agent.ExtendedAgent, pub ssh.PublicKey) ssh.Signer {
+	if sshAgent == nil {
+		return nil
+	}
+	signers, err := sshAgent.Signers()
+	if err != nil {
+		return nil
+	}
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), pub.Marshal()) {
+			return signer
+		}
+	}
+	return nil
+}
+
+// missingSecurityKey tells the user, once, that the FIDO security key in
+// keyFile can't be used because it isn't in the agent.
+func missingSecurityKey(keyFile string) {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	if skipped[keyFile] {
+		return
+	}
+	skipped[keyFile] = true
+	fmt.Fprintf(os.Stderr, "Skipping %s: security keys can only be used through ssh-agent; add it with ssh-add\n", keyFile)
+}
+
+// appendToKnownHosts adds key for hostname to the knownHosts file, unless it's
+// already there.
+func appendToKnownHosts(knownHosts, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+	// Another connection to the same host may have added the key since
+	// the file was read.
+	if check, err := knownhosts.New(knownHosts); err == nil && check(hostname, remote, key) == nil {
+		return nil
+	}
+	f, err := os.OpenFile(knownHosts, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
 	if err != nil {
 		return err
 	}
@@ -130,64 +434,275 @@ func appendToKnownHosts(hostname string, key ssh.PublicKey) error {
 	return f.Close()
 }
 
-// Dial establishes a new SFTP connection to the given host.
-func Dial(target string) (*FS, error) {
-	knownHostChecker, err := knownhosts.New(filepath.Join(os.Getenv("HOME"), ".ssh/known_hosts"))
+// confirmHostKey asks the user whether to trust an unknown host key, the same
+// way ssh does.
+func confirmHostKey(hostname string, remote net.Addr, key ssh.PublicKey) bool {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false
+	}
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	fmt.Fprintf(os.Stderr, "The authenticity of host '%s (%s)' can't be established.\n", hostname, remote)
+	fmt.Fprintf(os.Stderr, "%s key fingerprint is %s.\n", key.Type(), ssh.FingerprintSHA256(key))
+	fmt.Fprintf(os.Stderr, "Are you sure you want to continue connecting (yes/no)? ")
+	for {
+		var answer string
+		if _, err := fmt.Fscanln(os.Stdin, &answer); errors.Is(err, io.EOF) {
+			fmt.Fprintln(os.Stderr)
+			return false
+		}
+		switch strings.ToLower(answer) {
+		case "yes":
+			return true
+		case "no":
+			return false
+		}
+		fmt.Fprintf(os.Stderr, "Please type 'yes' or 'no': ")
+	}
+}
+
+// answerChallenge prompts the user for the answers to a keyboard-interactive
+// challenge, such as a one-time code. Answers that aren't echoed are read like
+// passwords.
+func answerChallenge(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	for _, s := range []string{name, instruction} {
+		if s != "" {
+			fmt.Fprintln(os.Stderr, s)
+		}
+	}
+	answers := make([]string, len(questions))
+	for i, q := range questions {
+		fmt.Fprint(os.Stderr, q)
+		if echos[i] {
+			var err error
+			if answers[i], err = readLine(os.Stdin); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		answer, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		answers[i] = string(answer)
+	}
+	return answers, nil
+}
+
+// readLine reads a line from r, a byte at a time so as not to read past it.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	var b [1]byte
+	for {
+		if _, err := r.Read(b[:]); err != nil {
+			return string(line), err
+		}
+		if b[0] == '\n' {
+			return strings.TrimSuffix(string(line), "\r"), nil
+		}
+		line = append(line, b[0])
+	}
+}
+
+// hostKeyCallback returns an [ssh.HostKeyCallback] that verifies host keys
+// against the knownHosts file, handling unknown keys according to checking.
+func hostKeyCallback(knownHosts string, checking HostKeyChecking) ssh.HostKeyCallback {
+	knownHostChecker, err := knownhosts.New(knownHosts)
 	if err != nil {
 		knownHostChecker = func(string, net.Addr, ssh.PublicKey) error { return &knownhosts.KeyError{} }
 	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := knownHostChecker(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			return err
+		}
+		switch checking {
+		case Strict:
+			return fmt.Errorf("host key for %s is not in %s", hostname, knownHosts)
+		case Ask:
+			if !confirmHostKey(hostname, remote, key) {
+				return fmt.Errorf("host key for %s was not accepted", hostname)
+			}
+		}
+		// scp prompts the user by default if the host is not found in
+		// known_hosts, but when is that ever useful? Unless asked to,
+		// we'll just add it to known_hosts without bothering the user.
+		appendToKnownHosts(knownHosts, hostname, remote, key)
+		return nil
+	}
+}
+
+// Dial establishes a new SFTP connection to the given host. The host may be an
+// alias from ~/.ssh/config, in which case its HostName, User, Port, and
+// IdentityFile settings are used. A user or port given explicitly takes
+// precedence over the config, and options in cfg.Options take precedence over
+// both.
+func Dial(target string, cfg Config) (*FS, error) {
+	for key, value := range cfg.Options {
+		if err := checkOption(key, value); err != nil {
+			return nil, err
+		}
+	}
 	var user string
 	if i := strings.Index(target, "@"); i >= 0 {
 		user, target = target[:i], target[i+1:]
-	} else {
+	} else if user = cfg.option(target, "User"); user == "" {
 		user = os.Getenv("USER")
 	}
-	sshConn, err := ssh.Dial("tcp", target+":22", &ssh.ClientConfig{
-		User: user,
+	if strings.HasPrefix(target, "[") && strings.HasSuffix(target, "]") {
+		// An IPv6 address in brackets, as written in a target.
+		target = target[1 : len(target)-1]
+	}
+	hostName := target
+	if h := cfg.option(target, "HostName"); h != "" {
+		hostName = strings.ReplaceAll(h, "%h", target)
+	}
+	port := cfg.Port
+	if p, ok := cfg.Options["Port"]; ok || port == 0 {
+		if !ok {
+			p = configValue(target, "Port")
+		}
+		if port, _ = strconv.Atoi(p); port == 0 {
+			port = 22
+		}
+	}
+	identityFiles := slices.Clone(cfg.IdentityFiles)
+	configFiles := configValues(target, "IdentityFile")
+	if f, ok := cfg.Options["IdentityFile"]; ok {
+		configFiles = append([]string{f}, configFiles...)
+	}
+	for _, f := range configFiles {
+		f, err := expandHome(f)
+		if err != nil {
+			return nil, err
+		}
+		identityFiles = append(identityFiles, f)
+	}
+	identitiesOnly := len(cfg.IdentityFiles) > 0 || cfg.option(target, "IdentitiesOnly") == "yes"
+	addr := net.JoinHostPort(hostName, strconv.Itoa(port))
+	knownHosts := cfg.KnownHosts
+	if f, ok := cfg.Options["UserKnownHostsFile"]; ok {
+		knownHosts = f
+	} else if knownHosts == "" {
+		knownHosts = "~/.ssh/known_hosts"
+	}
+	knownHosts, err := expandHome(knownHosts)
+	if err != nil {
+		return nil, err
+	}
+	hostKeyChecking := cfg.HostKeyChecking
+	if v, ok := cfg.Options["StrictHostKeyChecking"]; ok {
+		hostKeyChecking, _ = parseHostKeyChecking(v)
+	}
+	aliveInterval := cfg.ServerAliveInterval
+	if v, ok := cfg.Options["ServerAliveInterval"]; ok {
+		aliveInterval, _ = parseSeconds(v)
+	}
+	connectTimeout := cfg.ConnectTimeout
+	if v, ok := cfg.Options["ConnectTimeout"]; ok {
+		connectTimeout, _ = parseSeconds(v)
+	}
+	// There's no equivalent of scp -C here: golang.org/x/crypto/ssh only
+	// implements the "none" compression method, and offers no way to
+	// negotiate zlib@openssh.com.
+	clientConfig := &ssh.ClientConfig{
+		User:    user,
+		Timeout: connectTimeout,
 		Auth: []ssh.AuthMethod{
-			ssh.PublicKeysCallback(sshKeys),
+			ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				return sshKeys(identityFiles, identitiesOnly)
+			}),
 			ssh.RetryableAuthMethod(ssh.PasswordCallback(func() (string, error) {
+				promptMu.Lock()
+				defer promptMu.Unlock()
 				fmt.Fprintf(os.Stderr, "Enter password for %s@%s: ", user, target)
 				password, err := term.ReadPassword(int(os.Stdin.Fd()))
 				fmt.Fprintln(os.Stderr)
 				return string(password), err
 			}), 3),
+			// For servers that ask for more than a password, like
+			// a one-time code.
+			ssh.RetryableAuthMethod(ssh.KeyboardInteractive(answerChallenge), 3),
 		},
-		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			err := knownHostChecker(hostname, remote, key)
-			if err == nil {
-				return nil
-			}
-			var keyErr *knownhosts.KeyError
-			if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
-				return err
-			}
-			// scp prompts the user if the host is not found in
-			// known_hosts, but when is that ever useful? We'll just
-			// add it to known_hosts without bothering the user.
-			appendToKnownHosts(hostname, key)
-			return nil
-		},
-	})
+		HostKeyCallback: hostKeyCallback(knownHosts, hostKeyChecking),
+	}
+	if v, ok := cfg.Options["Ciphers"]; ok {
+		clientConfig.Ciphers = algorithms(v)
+	}
+	if v, ok := cfg.Options["MACs"]; ok {
+		clientConfig.MACs = algorithms(v)
+	}
+	if v, ok := cfg.Options["KexAlgorithms"]; ok {
+		clientConfig.KeyExchanges = algorithms(v)
+	}
+	if v, ok := cfg.Options["HostKeyAlgorithms"]; ok {
+		clientConfig.HostKeyAlgorithms = algorithms(v)
+	}
+	log := cfg.logger()
+	log.Debug("connecting", "host", target, "user", user, "addr", addr)
+	sshConn, err := ssh.Dial("tcp", addr, clientConfig)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("ssh connect to %s@%s: %w", user, target, err)
 	}
-	sftpConn, err := sftp.NewClient(sshConn)
+	sftpOptions := clientOptions(cfg)
+	sftpConn, err := sftp.NewClient(sshConn, sftpOptions...)
 	if err != nil {
 		sshConn.Close()
-		return nil, err
+		return nil, fmt.Errorf("sftp session with %s@%s: %w", user, target, err)
 	}
+	log.Info("connected", "host", target, "user", user, "addr", addr)
+	keepAlive(sshConn, aliveInterval, log)
+	conns := make([]*sftp.Client, max(cfg.Sessions, 1))
+	conns[0] = sftpConn
 	return &FS{
-		User:    user,
-		Host:    target,
-		conn:    sftpConn,
-		sshConn: sshConn,
+		User:          user,
+		Host:          target,
+		retries:       cfg.Retries,
+		log:           log,
+		addr:          addr,
+		clientConfig:  clientConfig,
+		aliveInterval: aliveInterval,
+		sftpOptions:   sftpOptions,
+		conns:         conns,
+		sshConn:       sshConn,
 	}, nil
 }
 
+// clientOptions returns the options for SFTP sessions set in cfg.
+func clientOptions(cfg Config) []sftp.ClientOption {
+	var opts []sftp.ClientOption
+	if cfg.MaxPacket > 0 {
+		opts = append(opts, sftp.MaxPacketUnchecked(cfg.MaxPacket))
+	}
+	if cfg.MaxRequests > 0 {
+		opts = append(opts, sftp.MaxConcurrentRequestsPerFile(cfg.MaxRequests))
+	}
+	return opts
+}
+
 // Close closes the underlying SFTP connection.
 func (f *FS) Close() error {
-	sftpErr := f.conn.Close()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var sftpErr error
+	for _, conn := range f.conns {
+		if conn == nil {
+			continue
+		}
+		if err := conn.Close(); sftpErr == nil {
+			sftpErr = err
+		}
+	}
+	if f.sshConn == nil {
+		return sftpErr
+	}
 	if err := f.sshConn.Close(); err != nil {
 		return err
 	}
@@ -197,21 +712,50 @@ func (f *FS) Close() error {
 func (f *FS) err(op, path string, err error) error {
 	// github.com/pkg/sftp's errors are pretty terrible.
 	// We'll wrap them to be more similar to the amazing package os errors.
-	return fmt.Errorf("%s %q: %w", op, f.User+"@"+f.Host+":"+path, err)
+	var status *sftp.StatusError
+	if errors.As(err, &status) && status.Code == fxNoSpaceOnFilesystem {
+		err = syscall.ENOSPC
+	}
+	return fmt.Errorf("%s %q: %w", op, f.Target(path), err)
+}
+
+// fxNoSpaceOnFilesystem is SSH_FX_NO_SPACE_ON_FILESYSTEM, which some servers
+// send for a full disk although it's from a later version of the protocol than
+// github.com/pkg/sftp speaks.
+const fxNoSpaceOnFilesystem = 14
+
+// Target returns path on the host as an scp-style target, user@host:path, with
+// an IPv6 address in brackets.
+func (f *FS) Target(path string) string {
+	host := f.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return f.User + "@" + host + ":" + path
 }
 
 // wfs.FS implementation:
 
 func (f *FS) Open(name string) (fs.File, error) {
-	file, err := f.conn.Open(name)
-	if err != nil {
+	r := &file{fsys: f, name: name}
+	if err := f.retry(func(conn *sftp.Client) error {
+		var err error
+		r.conn = conn
+		r.f, err = conn.Open(name)
+		return err
+	}); err != nil {
 		return nil, f.err("open", name, err)
 	}
-	return file, nil
+	return r, nil
 }
 
 func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
-	entriesFileInfo, err := f.conn.ReadDir(name)
+	var entriesFileInfo []fs.FileInfo
+	err := f.retry(func(conn *sftp.Client) error {
+		var err error
+		entriesFileInfo, err = conn.ReadDir(name)
+		return err
+	})
 	entries := make([]fs.DirEntry, len(entriesFileInfo))
 	for i, entry := range entriesFileInfo {
 		entries[i] = fs.FileInfoToDirEntry(entry)
@@ -223,65 +767,212 @@ func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
 }
 
 func (f *FS) Stat(name string) (fs.FileInfo, error) {
-	fi, err := f.conn.Stat(name)
-	if err != nil {
+	var fi fs.FileInfo
+	if err := f.retry(func(conn *sftp.Client) error {
+		var err error
+		fi, err = conn.Stat(name)
+		return err
+	}); err != nil {
 		return nil, f.err("stat", name, err)
 	}
 	return fi, nil
 }
 
+// Glob returns the names of the files on the remote host matching pattern, as
+// for [fs.Glob]. Errors reading directories are ignored.
+func (f *FS) Glob(pattern string) ([]string, error) {
+	var matches []string
+	err := f.retry(func(conn *sftp.Client) error {
+		var err error
+		matches, err = conn.Glob(pattern)
+		return err
+	})
+	slices.Sort(matches)
+	return matches, err
+}
+
+// Sub returns a view of the directory dir on the remote host, which may be
+// relative to the login directory or absolute.
+func (f *FS) Sub(dir string) (fs.FS, error) {
+	return wfs.Sub(f, dir), nil
+}
+
 func (f *FS) Lstat(name string) (fs.FileInfo, error) {
-	fi, err := f.conn.Lstat(name)
-	if err != nil {
+	var fi fs.FileInfo
+	if err := f.retry(func(conn *sftp.Client) error {
+		var err error
+		fi, err = conn.Lstat(name)
+		return err
+	}); err != nil {
 		return nil, f.err("lstat", name, err)
 	}
 	return fi, nil
 }
 
 func (f *FS) ReadLink(name string) (string, error) {
-	target, err := f.conn.ReadLink(name)
-	if err != nil {
+	var target string
+	if err := f.retry(func(conn *sftp.Client) error {
+		var err error
+		target, err = conn.ReadLink(name)
+		return err
+	}); err != nil {
 		return "", f.err("readlink", name, err)
 	}
 	return target, nil
 }
 
 func (f *FS) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
-	file, err := f.conn.Create(name)
-	if err != nil {
+	w := &writer{fsys: f, name: name}
+	if err := f.retry(func(conn *sftp.Client) error {
+		var err error
+		w.conn = conn
+		w.File, err = conn.Create(name)
+		return err
+	}); err != nil {
 		return nil, f.err("open", name, err)
 	}
-	if err := file.Chmod(perm); err != nil {
-		file.Close()
+	if err := w.Chmod(perm); err != nil {
+		w.Close()
 		return nil, f.err("chmod", name, err)
 	}
-	return file, nil
+	return w, nil
+}
+
+// A writer is a remote file opened for writing. Its errors name the file and
+// host.
+type writer struct {
+	*sftp.File
+	conn *sftp.Client // The session the file was opened with
+	fsys *FS
+	name string
+}
+
+func (w *writer) wrap(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return w.fsys.err(op, w.name, err)
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	n, err := w.File.Write(p)
+	return n, w.wrap("write", err)
+}
+
+func (w *writer) WriteAt(p []byte, off int64) (int, error) {
+	n, err := w.File.WriteAt(p, off)
+	return n, w.wrap("write", err)
+}
+
+// ReadFrom writes everything from r to the file, with several requests in
+// flight at once.
+func (w *writer) ReadFrom(r io.Reader) (int64, error) {
+	n, err := w.File.ReadFrom(r)
+	return n, w.wrap("write", err)
+}
+
+func (w *writer) Truncate(size int64) error {
+	return w.wrap("truncate", w.File.Truncate(size))
+}
+
+func (w *writer) Close() error {
+	return w.wrap("close", w.File.Close())
+}
+
+// Sync commits the file's data to disk on the server, using the
+// fsync@openssh.com extension. If the server doesn't support it, Sync returns
+// an error wrapping [errors.ErrUnsupported].
+func (w *writer) Sync() error {
+	if _, ok := w.conn.HasExtension("fsync@openssh.com"); !ok {
+		return w.wrap("fsync", errors.ErrUnsupported)
+	}
+	return w.wrap("fsync", w.File.Sync())
+}
+
+func (f *FS) OpenAppend(name string) (io.WriteCloser, error) {
+	w := &writer{conn: f.client(), fsys: f, name: name}
+	var err error
+	if w.File, err = w.conn.OpenFile(name, os.O_WRONLY); err != nil {
+		return nil, f.err("open", name, err)
+	}
+	// Not every server honors SSH_FXF_APPEND, so seek to the end
+	// explicitly.
+	if _, err := w.Seek(0, io.SeekEnd); err != nil {
+		w.File.Close()
+		return nil, f.err("seek", name, err)
+	}
+	return w, nil
 }
 
 func (f *FS) Remove(name string) error {
-	if err := f.conn.Remove(name); err != nil {
+	if err := f.client().Remove(name); err != nil {
 		return f.err("remove", name, err)
 	}
 	return nil
 }
 
 func (f *FS) Mkdir(name string) error {
-	if err := f.conn.Mkdir(name); err != nil {
+	if err := f.client().Mkdir(name); err != nil {
 		return f.err("mkdir", name, err)
 	}
 	return nil
 }
 
 func (f *FS) Symlink(oldname, newname string) error {
-	if err := f.conn.Symlink(oldname, newname); err != nil {
+	if err := f.client().Symlink(oldname, newname); err != nil {
 		return f.err("symlink", newname, err)
 	}
 	return nil
 }
 
+func (f *FS) Link(oldname, newname string) error {
+	if err := f.client().Link(oldname, newname); err != nil {
+		return f.err("link", newname, err)
+	}
+	return nil
+}
+
+// Rename moves oldname to newname. If the server supports it, Rename uses the
+// posix-rename@openssh.com extension, which replaces an existing newname.
+func (f *FS) Rename(oldname, newname string) error {
+	conn := f.client()
+	var err error
+	if _, ok := conn.HasExtension("posix-rename@openssh.com"); ok {
+		err = conn.PosixRename(oldname, newname)
+	} else {
+		err = conn.Rename(oldname, newname)
+	}
+	if err != nil {
+		return f.err("rename", oldname, err)
+	}
+	return nil
+}
+
 func (f *FS) Chmod(name string, mode fs.FileMode) error {
-	if err := f.conn.Chmod(name, mode); err != nil {
+	if err := f.client().Chmod(name, mode); err != nil {
 		return f.err("chmod", name, err)
 	}
 	return nil
 }
+
+func (f *FS) Chtimes(name string, atime, mtime time.Time) error {
+	if err := f.client().Chtimes(name, atime, mtime); err != nil {
+		return f.err("chtimes", name, err)
+	}
+	return nil
+}
+
+func (f *FS) Chown(name string, uid, gid int) error {
+	// SFTP has no lchown, so refuse to follow a symlink to its target.
+	fi, err := f.client().Lstat(name)
+	if err != nil {
+		return f.err("chown", name, err)
+	}
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		return f.err("chown", name, errors.ErrUnsupported)
+	}
+	if err := f.client().Chown(name, uid, gid); err != nil {
+		return f.err("chown", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,403 @@
+// Package spoolfs implements a [wfs.FS] that records everything written to it,
+// so that it can be serialized once the copy is done. File contents are
+// spooled to temporary files on the local disk.
+//
+// This is useful for archive formats, which need to know the size and metadata
+// of each entry before writing it, while [wfs.FS] users set metadata like
+// permissions and times after writing a file's contents.
+package spoolfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rhogenson/ccp/internal/wfs"
+)
+
+var (
+	_ wfs.FS          = (*FS)(nil)
+	_ wfs.MkdirModeFS = (*FS)(nil)
+	_ wfs.LchtimesFS  = (*FS)(nil)
+	_ wfs.ReadLinkFS  = (*FS)(nil)
+	_ fs.StatFS       = (*FS)(nil)
+	_ fs.ReadDirFS    = (*FS)(nil)
+)
+
+// An Entry is a file, directory, or symlink written to an [FS].
+type Entry struct {
+	// Name is the slash-separated path of the entry, relative to the
+	// root of the FS.
+	Name       string
+	Mode       fs.FileMode
+	ModTime    time.Time
+	AccessTime time.Time
+	Uid, Gid   int
+	// Size is the size of a regular file's contents.
+	Size int64
+	// Linkname is the target of a symlink.
+	Linkname string
+
+	seq  int    // Creation order
+	data string // Temporary file holding the contents of a regular file
+}
+
+// Open opens the contents of a regular file entry.
+func (e *Entry) Open() (io.ReadCloser, error) {
+	if !e.Mode.IsRegular() {
+		return nil, &fs.PathError{Op: "open", Path: e.Name, Err: fs.ErrInvalid}
+	}
+	return os.Open(e.data)
+}
+
+// An FS records the files written to it. The zero value is not usable; create
+// one with [New].
+type FS struct {
+	dir string
+
+	mu      sync.Mutex
+	seq     int
+	entries map[string]*Entry
+}
+
+// New creates an empty FS containing only the root directory.
+func New() (*FS, error) {
+	dir, err := os.MkdirTemp("", "ccp-spool-")
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &FS{
+		dir: dir,
+		entries: map[string]*Entry{
+			".": {Name: ".", Mode: fs.ModeDir | 0755, ModTime: now, AccessTime: now},
+		},
+	}, nil
+}
+
+// Entries returns everything written to the FS in the order it was created,
+// not including the root directory. Parent directories are always created
+// before their contents, so they come first.
+func (f *FS) Entries() []*Entry {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entries := make([]*Entry, 0, len(f.entries)-1)
+	for name, e := range f.entries {
+		if name != "." {
+			entries = append(entries, e)
+		}
+	}
+	slices.SortFunc(entries, func(a, b *Entry) int { return a.seq - b.seq })
+	return entries
+}
+
+// Close removes the spooled file contents.
+func (f *FS) Close() error {
+	return os.RemoveAll(f.dir)
+}
+
+// lookup returns the entry for name. The caller must hold f.mu.
+func (f *FS) lookup(op, name string) (*Entry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	e, ok := f.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return e, nil
+}
+
+// resolve returns the entry for name, following symlinks. The caller must
+// hold f.mu.
+func (f *FS) resolve(op, name string) (*Entry, error) {
+	for range 40 {
+		e, err := f.lookup(op, name)
+		if err != nil || e.Mode.Type() != fs.ModeSymlink {
+			return e, err
+		}
+		if path.IsAbs(e.Linkname) {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		name = path.Join(path.Dir(name), e.Linkname)
+	}
+	return nil, &fs.PathError{Op: op, Path: name, Err: errors.New("too many levels of symbolic links")}
+}
+
+// add creates a new entry. The caller must hold f.mu.
+func (f *FS) add(op, name string, mode fs.FileMode) (*Entry, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if _, ok := f.entries[name]; ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrExist}
+	}
+	if parent, err := f.resolve(op, path.Dir(name)); err != nil {
+		return nil, err
+	} else if !parent.Mode.IsDir() {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	now := time.Now()
+	f.seq++
+	e := &Entry{
+		Name:       name,
+		Mode:       mode,
+		ModTime:    now,
+		AccessTime: now,
+		Uid:        os.Getuid(),
+		Gid:        os.Getgid(),
+		seq:        f.seq,
+	}
+	f.entries[name] = e
+	return e, nil
+}
+
+func (f *FS) Open(name string) (fs.File, error) {
+	f.mu.Lock()
+	e, err := f.resolve("open", name)
+	f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if e.Mode.IsDir() {
+		return &dir{fileInfo{e}, f}, nil
+	}
+	file, err := os.Open(e.data)
+	if err != nil {
+		return nil, err
+	}
+	return &regular{file, fileInfo{e}}, nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, err := f.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{e}, nil
+}
+
+func (f *FS) Lstat(name string) (fs.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, err := f.lookup("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{e}, nil
+}
+
+func (f *FS) ReadLink(name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, err := f.lookup("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	if e.Mode.Type() != fs.ModeSymlink {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return e.Linkname, nil
+}
+
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, err := f.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.Mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	var entries []fs.DirEntry
+	for childName, child := range f.entries {
+		if childName != "." && path.Dir(childName) == e.Name {
+			entries = append(entries, fs.FileInfoToDirEntry(fileInfo{child}))
+		}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, nil
+}
+
+func (f *FS) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if e, ok := f.entries[name]; ok && e.Mode.IsRegular() {
+		// Truncate the existing file.
+		file, err := os.Create(e.data)
+		if err != nil {
+			return nil, err
+		}
+		return &writer{file, e, f}, nil
+	}
+	e, err := f.add("open", name, perm.Perm())
+	if err != nil {
+		return nil, err
+	}
+	e.data = filepath.Join(f.dir, strconv.Itoa(e.seq))
+	file, err := os.Create(e.data)
+	if err != nil {
+		delete(f.entries, name)
+		return nil, err
+	}
+	return &writer{file, e, f}, nil
+}
+
+func (f *FS) Remove(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, err := f.lookup("remove", name)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	if e.Mode.IsDir() {
+		for childName := range f.entries {
+			if childName != "." && path.Dir(childName) == name {
+				return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+			}
+		}
+	}
+	delete(f.entries, name)
+	if e.data != "" {
+		os.Remove(e.data)
+	}
+	return nil
+}
+
+func (f *FS) Mkdir(name string) error {
+	return f.MkdirMode(name, 0700)
+}
+
+func (f *FS) MkdirMode(name string, mode fs.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.add("mkdir", name, fs.ModeDir|mode.Perm())
+	return err
+}
+
+func (f *FS) Symlink(oldname, newname string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, err := f.add("symlink", newname, fs.ModeSymlink|0777)
+	if err != nil {
+		return err
+	}
+	e.Linkname = oldname
+	return nil
+}
+
+func (f *FS) Chmod(name string, mode fs.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, err := f.resolve("chmod", name)
+	if err != nil {
+		return err
+	}
+	e.Mode = e.Mode.Type() | mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)
+	return nil
+}
+
+func (f *FS) Chtimes(name string, atime, mtime time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, err := f.resolve("chtimes", name)
+	if err != nil {
+		return err
+	}
+	e.AccessTime, e.ModTime = atime, mtime
+	return nil
+}
+
+func (f *FS) Lchtimes(name string, atime, mtime time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, err := f.lookup("lchtimes", name)
+	if err != nil {
+		return err
+	}
+	e.AccessTime, e.ModTime = atime, mtime
+	return nil
+}
+
+func (f *FS) Chown(name string, uid, gid int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, err := f.lookup("chown", name)
+	if err != nil {
+		return err
+	}
+	e.Uid, e.Gid = uid, gid
+	return nil
+}
+
+// A writer writes the contents of a regular file entry.
+type writer struct {
+	*os.File
+	e    *Entry
+	fsys *FS
+}
+
+func (w *writer) Close() error {
+	stat, err := w.File.Stat()
+	if err != nil {
+		w.File.Close()
+		return err
+	}
+	w.fsys.mu.Lock()
+	w.e.Size = stat.Size()
+	w.fsys.mu.Unlock()
+	return w.File.Close()
+}
+
+type fileInfo struct {
+	e *Entry
+}
+
+func (fi fileInfo) Name() string       { return path.Base(fi.e.Name) }
+func (fi fileInfo) Size() int64        { return fi.e.Size }
+func (fi fileInfo) Mode() fs.FileMode  { return fi.e.Mode }
+func (fi fileInfo) ModTime() time.Time { return fi.e.ModTime }
+func (fi fileInfo) IsDir() bool        { return fi.e.Mode.IsDir() }
+func (fi fileInfo) Sys() any           { return nil }
+
+type regular struct {
+	*os.File
+	info fileInfo
+}
+
+func (r *regular) Stat() (fs.FileInfo, error) {
+	return r.info, nil
+}
+
+type dir struct {
+	info fileInfo
+	fsys *FS
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.e.Name, Err: fs.ErrInvalid}
+}
+func (d *dir) Close() error { return nil }
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, err := d.fsys.ReadDir(d.info.e.Name)
+	if n > 0 && len(entries) == 0 && err == nil {
+		return nil, io.EOF
+	}
+	return entries, err
+}
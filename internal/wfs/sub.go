@@ -0,0 +1,186 @@
+package wfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// Sub returns an [FS] corresponding to the subtree rooted at fsys's dir, like
+// [fs.Sub]. Names passed to it are joined to dir, and names in the errors it
+// returns have dir removed again.
+//
+// The returned file system implements [ReadLinkFS], [fs.StatFS],
+// [fs.ReadDirFS], [fs.GlobFS], and the optional interfaces in this package,
+// other than [XattrFS] and [ACLFS], by calling the corresponding helpers on fsys. Unlike
+// fs.Sub, dir may be any path that fsys accepts, such as an absolute path.
+func Sub(fsys FS, dir string) FS {
+	dir = path.Clean(dir)
+	if dir == "." {
+		return fsys
+	}
+	return &subFS{fsys, dir}
+}
+
+type subFS struct {
+	fsys FS
+	dir  string
+}
+
+var (
+	_ ReadLinkFS   = (*subFS)(nil)
+	_ LchtimesFS   = (*subFS)(nil)
+	_ MkdirModeFS  = (*subFS)(nil)
+	_ AppendFS     = (*subFS)(nil)
+	_ LinkFS       = (*subFS)(nil)
+	_ MknodFS      = (*subFS)(nil)
+	_ RenameFS     = (*subFS)(nil)
+	_ SyncDirFS    = (*subFS)(nil)
+	_ fs.StatFS    = (*subFS)(nil)
+	_ fs.ReadDirFS = (*subFS)(nil)
+	_ fs.GlobFS    = (*subFS)(nil)
+	_ fs.SubFS     = (*subFS)(nil)
+)
+
+// full returns the name in fsys of the file called name in f.
+func (f *subFS) full(name string) string {
+	return path.Join(f.dir, name)
+}
+
+// shorten returns the name in f of the file called name in fsys.
+func (f *subFS) shorten(name string) (string, bool) {
+	if name == f.dir {
+		return ".", true
+	}
+	return strings.CutPrefix(name, strings.TrimSuffix(f.dir, "/")+"/")
+}
+
+// fixErr shortens any path in err, which was returned by fsys.
+func (f *subFS) fixErr(err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		if short, ok := f.shorten(pathErr.Path); ok {
+			pathErr.Path = short
+		}
+	}
+	return err
+}
+
+func (f *subFS) Open(name string) (fs.File, error) {
+	file, err := f.fsys.Open(f.full(name))
+	return file, f.fixErr(err)
+}
+
+func (f *subFS) Stat(name string) (fs.FileInfo, error) {
+	fi, err := fs.Stat(f.fsys, f.full(name))
+	return fi, f.fixErr(err)
+}
+
+func (f *subFS) Lstat(name string) (fs.FileInfo, error) {
+	fi, err := Lstat(f.fsys, f.full(name))
+	return fi, f.fixErr(err)
+}
+
+func (f *subFS) ReadLink(name string) (string, error) {
+	target, err := ReadLink(f.fsys, f.full(name))
+	return target, f.fixErr(err)
+}
+
+func (f *subFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(f.fsys, f.full(name))
+	return entries, f.fixErr(err)
+}
+
+func (f *subFS) Glob(pattern string) ([]string, error) {
+	// Check the pattern first, since dir may itself contain
+	// metacharacters that would hide a bad pattern.
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	matches, err := fs.Glob(f.fsys, path.Join(escapeMeta(f.dir), pattern))
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range matches {
+		matches[i], _ = f.shorten(m)
+	}
+	return matches, nil
+}
+
+// escapeMeta escapes any glob metacharacters in name.
+func escapeMeta(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (f *subFS) Sub(dir string) (fs.FS, error) {
+	return Sub(f.fsys, f.full(dir)), nil
+}
+
+func (f *subFS) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	w, err := f.fsys.Create(f.full(name), perm)
+	return w, f.fixErr(err)
+}
+
+func (f *subFS) OpenAppend(name string) (io.WriteCloser, error) {
+	w, err := OpenAppend(f.fsys, f.full(name))
+	return w, f.fixErr(err)
+}
+
+func (f *subFS) Remove(name string) error {
+	return f.fixErr(f.fsys.Remove(f.full(name)))
+}
+
+func (f *subFS) Mkdir(name string) error {
+	return f.fixErr(f.fsys.Mkdir(f.full(name)))
+}
+
+func (f *subFS) MkdirMode(name string, mode fs.FileMode) error {
+	return f.fixErr(MkdirMode(f.fsys, f.full(name), mode))
+}
+
+func (f *subFS) Symlink(oldname, newname string) error {
+	// The target of a symlink is stored as it is, not resolved in f.
+	return f.fixErr(f.fsys.Symlink(oldname, f.full(newname)))
+}
+
+func (f *subFS) Link(oldname, newname string) error {
+	return f.fixErr(Link(f.fsys, f.full(oldname), f.full(newname)))
+}
+
+func (f *subFS) Rename(oldname, newname string) error {
+	return f.fixErr(Rename(f.fsys, f.full(oldname), f.full(newname)))
+}
+
+func (f *subFS) SyncDir(name string) error {
+	return f.fixErr(SyncDir(f.fsys, f.full(name)))
+}
+
+func (f *subFS) Mknod(name string, mode fs.FileMode, dev uint64) error {
+	return f.fixErr(Mknod(f.fsys, f.full(name), mode, dev))
+}
+
+func (f *subFS) Chmod(name string, mode fs.FileMode) error {
+	return f.fixErr(f.fsys.Chmod(f.full(name), mode))
+}
+
+func (f *subFS) Chtimes(name string, atime, mtime time.Time) error {
+	return f.fixErr(f.fsys.Chtimes(f.full(name), atime, mtime))
+}
+
+func (f *subFS) Lchtimes(name string, atime, mtime time.Time) error {
+	return f.fixErr(Lchtimes(f.fsys, f.full(name), atime, mtime))
+}
+
+func (f *subFS) Chown(name string, uid, gid int) error {
+	return f.fixErr(f.fsys.Chown(f.full(name), uid, gid))
+}
@@ -0,0 +1,327 @@
+// Package tarfs implements file systems backed by tar archives.
+//
+// A [Reader] provides random access to the entries of an archive by indexing
+// their headers up front. A [Writer] collects files until it's closed, then
+// writes them all to the archive in the order they were created.
+package tarfs
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rhogenson/ccp/internal/wfs"
+	"github.com/rhogenson/ccp/internal/wfs/spoolfs"
+)
+
+var (
+	_ wfs.FS         = (*Reader)(nil)
+	_ wfs.ReadLinkFS = (*Reader)(nil)
+	_ fs.StatFS      = (*Reader)(nil)
+	_ fs.ReadDirFS   = (*Reader)(nil)
+)
+
+// An entry is a file in the archive.
+type entry struct {
+	hdr      *tar.Header
+	offset   int64         // Offset of the file's contents in the archive
+	children []fs.DirEntry // Sorted, for directories
+}
+
+// A Reader is a read-only file system containing the files in a tar archive.
+type Reader struct {
+	r       io.ReaderAt
+	entries map[string]*entry
+}
+
+// cleanName converts the name of a tar entry into an fs.FS path.
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// NewReader indexes the tar archive of the given size read from r.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	fsys := &Reader{
+		r: r,
+		entries: map[string]*entry{
+			".": {hdr: &tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755}},
+		},
+	}
+	sr := io.NewSectionReader(r, 0, size)
+	tr := tar.NewReader(sr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := cleanName(hdr.Name)
+		if name == "" {
+			continue
+		}
+		// The tar reader stops right after the header, so the current
+		// offset is where the contents start.
+		offset, _ := sr.Seek(0, io.SeekCurrent)
+		fsys.entries[name] = &entry{hdr: hdr, offset: offset}
+	}
+	for name, e := range fsys.entries {
+		if e.hdr.Typeflag != tar.TypeLink {
+			continue
+		}
+		// A hard link shares the contents of an earlier entry.
+		target, ok := fsys.entries[cleanName(e.hdr.Linkname)]
+		if !ok || target.hdr.Typeflag != tar.TypeReg {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("hard link to missing file " + e.hdr.Linkname)}
+		}
+		hdr := *e.hdr
+		hdr.Typeflag, hdr.Size, hdr.Linkname = tar.TypeReg, target.hdr.Size, ""
+		e.hdr, e.offset = &hdr, target.offset
+	}
+	for name := range fsys.entries {
+		// Archives don't have to include every parent directory, so
+		// make up any that are missing.
+		for name != "." {
+			name = path.Dir(name)
+			if _, ok := fsys.entries[name]; ok {
+				break
+			}
+			fsys.entries[name] = &entry{hdr: &tar.Header{
+				Name:     name + "/",
+				Typeflag: tar.TypeDir,
+				Mode:     0755,
+			}}
+		}
+	}
+	for name, e := range fsys.entries {
+		if name == "." {
+			continue
+		}
+		parent := fsys.entries[path.Dir(name)]
+		parent.children = append(parent.children, fs.FileInfoToDirEntry(e.hdr.FileInfo()))
+	}
+	for _, e := range fsys.entries {
+		slices.SortFunc(e.children, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	}
+	return fsys, nil
+}
+
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+func (fsys *Reader) lookup(op, name string) (*entry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	e, ok := fsys.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return e, nil
+}
+
+// resolve returns the entry for name, following symlinks within the archive.
+func (fsys *Reader) resolve(op, name string) (*entry, error) {
+	for range 40 {
+		e, err := fsys.lookup(op, name)
+		if err != nil || e.hdr.Typeflag != tar.TypeSymlink {
+			return e, err
+		}
+		if path.IsAbs(e.hdr.Linkname) {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		name = path.Join(path.Dir(name), e.hdr.Linkname)
+	}
+	return nil, &fs.PathError{Op: op, Path: name, Err: errors.New("too many levels of symbolic links")}
+}
+
+func (fsys *Reader) Open(name string) (fs.File, error) {
+	e, err := fsys.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	switch e.hdr.Typeflag {
+	case tar.TypeDir:
+		return &dir{e: e}, nil
+	case tar.TypeReg, tar.TypeRegA:
+		if isSparse(e.hdr) {
+			// The contents of sparse files are stored
+			// compacted with a map of the holes, which
+			// the tar package doesn't expose.
+			break
+		}
+		return &file{io.NewSectionReader(fsys.r, e.offset, e.hdr.Size), e}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: errors.ErrUnsupported}
+}
+
+func (fsys *Reader) Stat(name string) (fs.FileInfo, error) {
+	e, err := fsys.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return e.hdr.FileInfo(), nil
+}
+
+func (fsys *Reader) Lstat(name string) (fs.FileInfo, error) {
+	e, err := fsys.lookup("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return e.hdr.FileInfo(), nil
+}
+
+func (fsys *Reader) ReadLink(name string) (string, error) {
+	e, err := fsys.lookup("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	if e.hdr.Typeflag != tar.TypeSymlink {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return e.hdr.Linkname, nil
+}
+
+func (fsys *Reader) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, err := fsys.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.hdr.Typeflag != tar.TypeDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return slices.Clone(e.children), nil
+}
+
+func readOnly(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+}
+
+func (fsys *Reader) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	return nil, readOnly("open", name)
+}
+func (fsys *Reader) Remove(name string) error                  { return readOnly("remove", name) }
+func (fsys *Reader) Mkdir(name string) error                   { return readOnly("mkdir", name) }
+func (fsys *Reader) Symlink(oldname, newname string) error     { return readOnly("symlink", newname) }
+func (fsys *Reader) Chmod(name string, mode fs.FileMode) error { return readOnly("chmod", name) }
+func (fsys *Reader) Chtimes(name string, atime, mtime time.Time) error {
+	return readOnly("chtimes", name)
+}
+func (fsys *Reader) Chown(name string, uid, gid int) error { return readOnly("chown", name) }
+
+type file struct {
+	*io.SectionReader
+	e *entry
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return f.e.hdr.FileInfo(), nil }
+func (f *file) Close() error               { return nil }
+
+type dir struct {
+	e   *entry
+	off int
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.e.hdr.FileInfo(), nil }
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.e.hdr.Name, Err: fs.ErrInvalid}
+}
+func (d *dir) Close() error { return nil }
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.e.children[d.off:]
+	if n <= 0 {
+		d.off += len(rest)
+		return slices.Clone(rest), nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	rest = rest[:min(n, len(rest))]
+	d.off += len(rest)
+	return slices.Clone(rest), nil
+}
+
+// A Writer is a write-only file system that saves the files created in it to
+// a tar archive when closed. File contents are spooled to temporary files
+// until then.
+type Writer struct {
+	*spoolfs.FS
+	tw *tar.Writer
+}
+
+// NewWriter returns a Writer that writes an archive to w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	spool, err := spoolfs.New()
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{spool, tar.NewWriter(w)}, nil
+}
+
+// Close writes the archive and removes the spooled files. It does not close
+// the underlying writer.
+func (w *Writer) Close() error {
+	defer w.FS.Close()
+	for _, e := range w.Entries() {
+		hdr := &tar.Header{
+			Name:       e.Name,
+			Mode:       int64(e.Mode.Perm()),
+			Uid:        e.Uid,
+			Gid:        e.Gid,
+			ModTime:    e.ModTime,
+			AccessTime: e.AccessTime,
+		}
+		if e.Mode&fs.ModeSetuid != 0 {
+			hdr.Mode |= 04000
+		}
+		if e.Mode&fs.ModeSetgid != 0 {
+			hdr.Mode |= 02000
+		}
+		if e.Mode&fs.ModeSticky != 0 {
+			hdr.Mode |= 01000
+		}
+		switch e.Mode.Type() {
+		case fs.ModeDir:
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+		case fs.ModeSymlink:
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = e.Linkname
+		default:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = e.Size
+		}
+		if err := w.tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		r, err := e.Open()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w.tw, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return w.tw.Close()
+}
@@ -3,9 +3,14 @@ package wfs
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
+	"math/rand/v2"
 	"path"
+	"strconv"
+	"syscall"
+	"time"
 )
 
 // ReadLinkFS is backported from the latest go master.
@@ -50,6 +55,68 @@ type FS interface {
 	Mkdir(string) error
 	Symlink(string, string) error
 	Chmod(string, fs.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+	// Chown changes the numeric uid and gid of the named file. If the
+	// file is a symbolic link, Chown changes the owner of the link itself,
+	// or returns an error wrapping [errors.ErrUnsupported] if it can't.
+	Chown(name string, uid, gid int) error
+}
+
+// A LchtimesFS is a file system that can change the times of a symbolic link
+// without following it.
+type LchtimesFS interface {
+	FS
+
+	Lchtimes(name string, atime, mtime time.Time) error
+}
+
+// An XattrFS is a file system that supports extended attributes. None of its
+// methods follow symbolic links.
+type XattrFS interface {
+	FS
+
+	ListXattr(name string) ([]string, error)
+	GetXattr(name, attr string) ([]byte, error)
+	SetXattr(name, attr string, data []byte) error
+}
+
+// An ACLType says which of a file's POSIX access control lists an [ACLFS]
+// method is about.
+type ACLType int
+
+// The kinds of [ACLType].
+const (
+	AccessACL  ACLType = iota // Who may access the file itself
+	DefaultACL                // What files created in a directory inherit
+)
+
+// An ACLFS is a file system that supports POSIX access control lists. ACLs are
+// in the binary format of Linux's system.posix_acl_access and
+// system.posix_acl_default extended attributes. None of its methods follow
+// symbolic links.
+type ACLFS interface {
+	FS
+
+	// GetACL returns the ACL of the given type of the named file, or nil
+	// if it has none beyond its mode bits.
+	GetACL(name string, typ ACLType) ([]byte, error)
+	// SetACL replaces the ACL of the given type of the named file. A nil
+	// acl removes it. If the file system can't store ACLs, SetACL returns
+	// an error wrapping [errors.ErrUnsupported].
+	SetACL(name string, typ ACLType, acl []byte) error
+}
+
+// Lchtimes changes the access and modification times of the named file. If the
+// file is a symbolic link, Lchtimes changes the times of the link itself.
+//
+// If fsys does not implement [LchtimesFS], then Lchtimes returns an error
+// wrapping [errors.ErrUnsupported].
+func Lchtimes(fsys FS, name string, atime, mtime time.Time) error {
+	sym, ok := fsys.(LchtimesFS)
+	if !ok {
+		return &fs.PathError{Op: "lchtimes", Path: name, Err: errors.ErrUnsupported}
+	}
+	return sym.Lchtimes(name, atime, mtime)
 }
 
 // A MkdirModeFS is a file system with a mkdir method that accepts a file mode.
@@ -72,6 +139,177 @@ func MkdirMode(fsys FS, name string, mode fs.FileMode) error {
 	return fsys.Chmod(name, mode)
 }
 
+// An AppendFS is a file system that can open an existing file for appending.
+type AppendFS interface {
+	FS
+
+	OpenAppend(string) (io.WriteCloser, error)
+}
+
+// OpenAppend opens the named file for writing at its end, without truncating
+// it.
+//
+// If fsys does not implement [AppendFS], then OpenAppend returns an error
+// wrapping [errors.ErrUnsupported].
+func OpenAppend(fsys FS, name string) (io.WriteCloser, error) {
+	afs, ok := fsys.(AppendFS)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.ErrUnsupported}
+	}
+	return afs.OpenAppend(name)
+}
+
+// A LinkFS is a file system that supports hard links.
+type LinkFS interface {
+	FS
+
+	Link(oldname, newname string) error
+}
+
+// Link creates newname as a hard link to the oldname file.
+//
+// If fsys does not implement [LinkFS], then Link returns an error wrapping
+// [errors.ErrUnsupported].
+func Link(fsys FS, oldname, newname string) error {
+	lfs, ok := fsys.(LinkFS)
+	if !ok {
+		return &fs.PathError{Op: "link", Path: newname, Err: errors.ErrUnsupported}
+	}
+	return lfs.Link(oldname, newname)
+}
+
+// A RenameFS is a file system that can move files.
+type RenameFS interface {
+	FS
+
+	// Rename moves oldname to newname, replacing newname if it's an
+	// existing file.
+	Rename(oldname, newname string) error
+}
+
+// Rename moves oldname to newname.
+//
+// If fsys does not implement [RenameFS], then Rename returns an error wrapping
+// [errors.ErrUnsupported].
+func Rename(fsys FS, oldname, newname string) error {
+	rfs, ok := fsys.(RenameFS)
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: errors.ErrUnsupported}
+	}
+	return rfs.Rename(oldname, newname)
+}
+
+// CreateTemp creates a new file in the directory dir with the given
+// permissions, and returns it along with its name. Like [os.CreateTemp], the
+// name is pattern followed by a random string. A name is only used if nothing
+// has it, but since FS has no way to create a file exclusively, two callers
+// racing for the same random name could both get it.
+func CreateTemp(fsys FS, dir, pattern string, perm fs.FileMode) (io.WriteCloser, string, error) {
+	for range 100 {
+		name := path.Join(dir, pattern+strconv.FormatUint(rand.Uint64(), 36))
+		if _, err := Lstat(fsys, name); err == nil {
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, "", err
+		}
+		w, err := fsys.Create(name, perm)
+		return w, name, err
+	}
+	return nil, "", &fs.PathError{Op: "createtemp", Path: path.Join(dir, pattern+"*"), Err: fs.ErrExist}
+}
+
+// Sync commits the data written to w, a file returned by [FS.Create] or
+// [AppendFS.OpenAppend], to stable storage.
+//
+// If w doesn't have a Sync method, like [os.File.Sync], then Sync returns an
+// error wrapping [errors.ErrUnsupported].
+func Sync(w io.Writer) error {
+	s, ok := w.(interface{ Sync() error })
+	if !ok {
+		return fmt.Errorf("sync: %w", errors.ErrUnsupported)
+	}
+	return s.Sync()
+}
+
+// A SyncDirFS is a file system that can commit the entries of a directory to
+// stable storage, so that files created in it survive a crash.
+type SyncDirFS interface {
+	FS
+
+	SyncDir(name string) error
+}
+
+// SyncDir commits the entries of the directory name to stable storage.
+//
+// If fsys does not implement [SyncDirFS], then SyncDir returns an error
+// wrapping [errors.ErrUnsupported].
+func SyncDir(fsys FS, name string) error {
+	sfs, ok := fsys.(SyncDirFS)
+	if !ok {
+		return &fs.PathError{Op: "sync", Path: name, Err: errors.ErrUnsupported}
+	}
+	return sfs.SyncDir(name)
+}
+
+// A MknodFS is a file system that can create special files.
+type MknodFS interface {
+	FS
+
+	Mknod(name string, mode fs.FileMode, dev uint64) error
+}
+
+// Mknod creates a FIFO, socket, or device node named name, according to the
+// type bits of mode, with the permission bits of mode. For a device node, dev
+// is its device number.
+//
+// If fsys does not implement [MknodFS], then Mknod returns an error wrapping
+// [errors.ErrUnsupported].
+func Mknod(fsys FS, name string, mode fs.FileMode, dev uint64) error {
+	mfs, ok := fsys.(MknodFS)
+	if !ok {
+		return &fs.PathError{Op: "mknod", Path: name, Err: errors.ErrUnsupported}
+	}
+	return mfs.Mknod(name, mode, dev)
+}
+
+// MkdirAll creates a directory named name, along with any necessary parents,
+// and returns nil, or else returns an error. New directories are created with
+// mode 0777, before the umask, if fsys implements [MkdirModeFS], and with
+// fsys.Mkdir otherwise. If name is already a directory, MkdirAll does nothing
+// and returns nil.
+func MkdirAll(fsys FS, name string) error {
+	// Fast path: if we can tell whether name is a directory or file, stop
+	// with success or error.
+	dir, err := fs.Stat(fsys, name)
+	if err == nil {
+		if dir.IsDir() {
+			return nil
+		}
+		return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	// Slow path: make sure parent exists and then call Mkdir for name.
+	if parent := path.Dir(name); parent != name {
+		if err := MkdirAll(fsys, parent); err != nil {
+			return err
+		}
+	}
+	mkdir := fsys.Mkdir
+	if fsys, ok := fsys.(MkdirModeFS); ok {
+		mkdir = func(name string) error { return fsys.MkdirMode(name, 0777) }
+	}
+	if err := mkdir(name); err != nil {
+		// Handle arguments like "foo/." by double-checking that
+		// directory doesn't exist.
+		dir, err1 := Lstat(fsys, name)
+		if err1 == nil && dir.IsDir() {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
 func removeDir(fsys FS, dir string) error {
 	entries, readErr := fs.ReadDir(fsys, dir)
 	var err error
@@ -0,0 +1,224 @@
+// Package zipfs implements file systems backed by zip archives.
+//
+// Zip has no native symlinks. Like Info-ZIP, symlinks are stored as entries
+// whose Unix mode, kept in the external attributes, marks them as links, and
+// whose contents are the link target.
+package zipfs
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rhogenson/ccp/internal/wfs"
+	"github.com/rhogenson/ccp/internal/wfs/spoolfs"
+)
+
+var (
+	_ wfs.FS         = (*Reader)(nil)
+	_ wfs.ReadLinkFS = (*Reader)(nil)
+	_ fs.StatFS      = (*Reader)(nil)
+	_ fs.ReadDirFS   = (*Reader)(nil)
+)
+
+// A Reader is a read-only file system containing the files in a zip archive.
+type Reader struct {
+	zr   *zip.Reader
+	dirs map[string]*zip.FileHeader
+}
+
+// NewReader reads the zip archive of the given size from r.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	dirs := make(map[string]*zip.FileHeader)
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			dirs[path.Clean(f.Name)] = &f.FileHeader
+		}
+	}
+	return &Reader{zr, dirs}, nil
+}
+
+// dirInfo returns info about the directory name. zip.Reader reports every
+// directory as read-only, so take the mode from the archive instead.
+func (fsys *Reader) dirInfo(name string, fi fs.FileInfo) fs.FileInfo {
+	if hdr, ok := fsys.dirs[name]; ok {
+		return hdr.FileInfo()
+	}
+	// Directories can be left out of the archive.
+	return implicitDir{fi}
+}
+
+type implicitDir struct {
+	fs.FileInfo
+}
+
+func (implicitDir) Mode() fs.FileMode { return fs.ModeDir | 0755 }
+
+// resolve follows any symlink named by name within the archive.
+func (fsys *Reader) resolve(op, name string) (string, error) {
+	for range 40 {
+		stat, err := fsys.Lstat(name)
+		if err != nil {
+			return "", err
+		}
+		if stat.Mode().Type() != fs.ModeSymlink {
+			return name, nil
+		}
+		target, err := fsys.ReadLink(name)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		name = path.Join(path.Dir(name), target)
+	}
+	return "", &fs.PathError{Op: op, Path: name, Err: errors.New("too many levels of symbolic links")}
+}
+
+func (fsys *Reader) Open(name string) (fs.File, error) {
+	name, err := fsys.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.zr.Open(name)
+}
+
+func (fsys *Reader) Stat(name string) (fs.FileInfo, error) {
+	name, err := fsys.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.Lstat(name)
+}
+
+func (fsys *Reader) Lstat(name string) (fs.FileInfo, error) {
+	// zip.Reader doesn't treat symlinks specially, so its Stat is really
+	// an lstat.
+	stat, err := fs.Stat(fsys.zr, name)
+	if err != nil || !stat.IsDir() {
+		return stat, err
+	}
+	return fsys.dirInfo(name, stat), nil
+}
+
+func (fsys *Reader) ReadLink(name string) (string, error) {
+	f, err := fsys.zr.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if stat.Mode().Type() != fs.ModeSymlink {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	target, err := io.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	return string(target), nil
+}
+
+func (fsys *Reader) ReadDir(name string) ([]fs.DirEntry, error) {
+	name, err := fsys.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fs.ReadDir(fsys.zr, name)
+	for i, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = fs.FileInfoToDirEntry(fsys.dirInfo(path.Join(name, e.Name()), info))
+	}
+	return entries, err
+}
+
+func readOnly(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+}
+
+func (fsys *Reader) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	return nil, readOnly("open", name)
+}
+func (fsys *Reader) Remove(name string) error                  { return readOnly("remove", name) }
+func (fsys *Reader) Mkdir(name string) error                   { return readOnly("mkdir", name) }
+func (fsys *Reader) Symlink(oldname, newname string) error     { return readOnly("symlink", newname) }
+func (fsys *Reader) Chmod(name string, mode fs.FileMode) error { return readOnly("chmod", name) }
+func (fsys *Reader) Chtimes(name string, atime, mtime time.Time) error {
+	return readOnly("chtimes", name)
+}
+func (fsys *Reader) Chown(name string, uid, gid int) error { return readOnly("chown", name) }
+
+// A Writer is a write-only file system that saves the files created in it to
+// a zip archive when closed. File contents are spooled to temporary files
+// until then.
+type Writer struct {
+	*spoolfs.FS
+	zw *zip.Writer
+}
+
+// NewWriter returns a Writer that writes an archive to w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	spool, err := spoolfs.New()
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{spool, zip.NewWriter(w)}, nil
+}
+
+// Close writes the archive and removes the spooled files. It does not close
+// the underlying writer.
+func (w *Writer) Close() error {
+	defer w.FS.Close()
+	for _, e := range w.Entries() {
+		hdr := &zip.FileHeader{
+			Name:     e.Name,
+			Modified: e.ModTime,
+		}
+		hdr.SetMode(e.Mode)
+		switch e.Mode.Type() {
+		case fs.ModeDir:
+			hdr.Name += "/"
+		case fs.ModeSymlink:
+		default:
+			hdr.Method = zip.Deflate
+		}
+		fw, err := w.zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		switch e.Mode.Type() {
+		case fs.ModeSymlink:
+			if _, err := io.WriteString(fw, e.Linkname); err != nil {
+				return err
+			}
+		case 0:
+			r, err := e.Open()
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(fw, r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return w.zw.Close()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rhogenson/ccp/internal/cp"
+)
+
+type fileEvent struct {
+	Event string `json:"event"` // "start" or "done"
+	Src   string `json:"src"`
+	Dst   string `json:"dst"`
+	Size  int64  `json:"size,omitempty"` // Only for "start"
+	Error string `json:"error,omitempty"`
+}
+
+type errorEvent struct {
+	Event string `json:"event"` // "error" or "warning"
+	Error string `json:"error"`
+}
+
+type progressEvent struct {
+	Event       string `json:"event"` // "progress"
+	Bytes       int64  `json:"bytes"`
+	Total       int64  `json:"total"`
+	Files       int    `json:"files"`
+	TotalFiles  int    `json:"total_files"`
+	BytesPerSec *int64 `json:"bytes_per_sec,omitempty"` // Omitted until known
+	ETASeconds  *int64 `json:"eta_seconds,omitempty"`
+}
+
+type summaryEvent struct {
+	Event           string  `json:"event"` // "summary"
+	Files           int     `json:"files"`
+	Bytes           int64   `json:"bytes"`
+	Skipped         int     `json:"skipped"`
+	Failed          int     `json:"failed"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	DryRun          bool    `json:"dry_run,omitempty"`
+	TimesOnly       bool    `json:"times_only,omitempty"`
+}
+
+// jsonProgress implements the cp.Progress interface by writing newline
+// delimited JSON events to stdout.
+type jsonProgress struct {
+	mu       sync.Mutex
+	enc      *json.Encoder
+	max      int64
+	current  int64
+	maxFiles int
+	files    int // Regular files copied
+	failed   bool
+}
+
+func (jp *jsonProgress) emit(event any) {
+	jp.mu.Lock()
+	defer jp.mu.Unlock()
+	jp.enc.Encode(event)
+}
+
+func (jp *jsonProgress) Max(n int64) {
+	jp.mu.Lock()
+	defer jp.mu.Unlock()
+	jp.max = n
+}
+
+func (jp *jsonProgress) MaxFiles(n int) {
+	jp.mu.Lock()
+	defer jp.mu.Unlock()
+	jp.maxFiles = n
+}
+
+func (jp *jsonProgress) Progress(n int64) {
+	jp.mu.Lock()
+	defer jp.mu.Unlock()
+	jp.current += n
+}
+
+func (jp *jsonProgress) FileStart(src, dst string, size int64) {
+	jp.emit(fileEvent{Event: "start", Src: src, Dst: dst, Size: size})
+}
+
+func (jp *jsonProgress) FileDone(src, dst string, err error) {
+	event := fileEvent{Event: "done", Src: src, Dst: dst}
+	jp.mu.Lock()
+	if err != nil {
+		event.Error = err.Error()
+		jp.failed = true
+	} else {
+		jp.files++
+	}
+	jp.mu.Unlock()
+	jp.emit(event)
+}
+
+func (jp *jsonProgress) Error(err error) {
+	jp.mu.Lock()
+	jp.failed = true
+	jp.mu.Unlock()
+	jp.emit(errorEvent{Event: "error", Error: err.Error()})
+}
+
+func (jp *jsonProgress) Warning(err error) {
+	jp.emit(errorEvent{Event: "warning", Error: err.Error()})
+}
+
+func (jp *jsonProgress) Summary(r cp.Result) {
+	jp.emit(summaryEvent{
+		Event:           "summary",
+		Files:           r.Files,
+		Bytes:           r.Bytes,
+		Skipped:         r.Skipped,
+		Failed:          r.Failed,
+		DurationSeconds: r.Duration.Seconds(),
+		DryRun:          r.DryRun,
+		TimesOnly:       r.TimesOnly,
+	})
+}
+
+func (jp *jsonProgress) snapshot(now time.Time, speedometer *speedometer) {
+	jp.mu.Lock()
+	event := progressEvent{
+		Event:      "progress",
+		Bytes:      jp.current,
+		Total:      jp.max,
+		Files:      jp.files,
+		TotalFiles: jp.maxFiles,
+	}
+	jp.mu.Unlock()
+	speedometer.measure(now, event.Bytes, event.Total)
+	if speedometer.speed >= 0 {
+		speed := int64(speedometer.speed)
+		event.BytesPerSec = &speed
+	}
+	if speedometer.eta >= 0 {
+		eta := int64(speedometer.eta.Round(time.Second) / time.Second)
+		event.ETASeconds = &eta
+	}
+	jp.emit(event)
+}
+
+// copyJSON copies srcs to dst, reporting progress as JSON events on stdout
+// instead of drawing a progress bar.
+func copyJSON(ctx context.Context, srcs []cp.FSPath, dst cp.FSPath, opts cp.Options) error {
+	jp := &jsonProgress{enc: json.NewEncoder(os.Stdout)}
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		cp.Copy(ctx, jp, srcs, dst, opts)
+	}()
+
+	speedometer := newSpeedometer()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for done := false; !done; {
+		var now time.Time
+		select {
+		case <-doneCh:
+			done, now = true, time.Now()
+		case now = <-ticker.C:
+		}
+		jp.snapshot(now, speedometer)
+	}
+	if jp.failed {
+		return errCopyFailed
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+)
+
+// openLog returns the logger for -log-file and -log-level, or nil if neither
+// was given, so that nothing is logged by default. With only -log-level, logs
+// go to stderr. The returned io.Closer, if not nil, closes the log file.
+func openLog(name, level string) (*slog.Logger, io.Closer, error) {
+	if name == "" && level == "" {
+		return nil, nil, nil
+	}
+	var opts slog.HandlerOptions
+	if level != "" {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return nil, nil, usageError("-log-level must be debug, info, warn, or error, not %q", level)
+		}
+		opts.Level = l
+	}
+	if name == "" {
+		return slog.New(slog.NewTextHandler(os.Stderr, &opts)), nil, nil
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o666)
+	if err != nil {
+		return nil, nil, err
+	}
+	return slog.New(slog.NewTextHandler(f, &opts)), f, nil
+}
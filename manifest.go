@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rhogenson/ccp/internal/cp"
+)
+
+// A manifest records each file copied for -manifest, as a line of JSON if the
+// file's name ends in .json or .jsonl, and otherwise as a line of
+// tab-separated values. Each record is written as soon as its file is copied,
+// so that the manifest of an interrupted copy is still accurate.
+type manifest struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder // Nil for TSV
+	err error         // The first error writing the manifest
+}
+
+type manifestEntry struct {
+	Src    string `json:"src"`
+	Dst    string `json:"dst"`
+	Size   int64  `json:"size"`
+	Mode   string `json:"mode"`
+	SHA256 string `json:"sha256,omitempty"` // Only with -c
+}
+
+func createManifest(name string) (*manifest, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{f: f}
+	if strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".jsonl") {
+		m.enc = json.NewEncoder(f)
+	} else if _, err := fmt.Fprintln(f, "src\tdst\tsize\tmode\tsha256"); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// record writes r to the manifest. It's used as cp.Options.Copied.
+func (m *manifest) record(r cp.Record) {
+	e := manifestEntry{
+		Src:    r.Src.String(),
+		Dst:    r.Dst.String(),
+		Size:   r.Size,
+		Mode:   r.Mode.String(),
+		SHA256: hex.EncodeToString(r.SHA256),
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return
+	}
+	if m.enc != nil {
+		m.err = m.enc.Encode(e)
+	} else {
+		_, m.err = fmt.Fprintf(m.f, "%s\t%s\t%d\t%s\t%s\n", e.Src, e.Dst, e.Size, e.Mode, e.SHA256)
+	}
+}
+
+// Close closes the manifest, returning the first error writing it.
+func (m *manifest) Close() error {
+	err := m.err
+	if closeErr := m.f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	return nil
+}
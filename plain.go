@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rhogenson/ccp/internal/cp"
+)
+
+// copyPlain copies srcs to dst, printing a line of progress to stderr every
+// interval rather than redrawing a progress bar, which suits logs. Errors and
+// warnings are printed along with the progress lines. If -v is set, the files
+// copied are listed on verboseOut.
+func copyPlain(ctx context.Context, srcs []cp.FSPath, dst cp.FSPath, opts cp.Options, interval time.Duration, verboseOut io.Writer) error {
+	pu := &progressUpdater{verbose: *v}
+	doneCh := make(chan struct{})
+	start := time.Now()
+	go func() {
+		defer close(doneCh)
+		cp.Copy(ctx, pu, srcs, dst, opts)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	printed := 0 // Number of pu.errs already printed
+	for done := false; !done; {
+		select {
+		case <-doneCh:
+			done = true
+		case <-ticker.C:
+		}
+
+		pu.mu.Lock()
+		current := pu.current
+		maxBytes := pu.max
+		files := pu.files
+		maxFiles := pu.maxFiles
+		errs := pu.errs[printed:]
+		printed = len(pu.errs)
+		copied := pu.copied
+		pu.copied = nil
+		pu.mu.Unlock()
+
+		for _, line := range copied {
+			fmt.Fprintln(verboseOut, line)
+		}
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if done {
+			break
+		}
+		percent := 0.
+		if maxBytes > 0 {
+			percent = 100 * float64(current) / float64(maxBytes)
+		}
+		fmt.Fprintf(os.Stderr, "[%.0f%%] %d / %d files, %s / %s\n", percent, files, maxFiles, formatBytes(current), formatBytes(maxBytes))
+	}
+	return pu.finish(time.Since(start))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/rhogenson/ccp/internal/cp"
+)
+
+// quietProgress implements the cp.Progress interface by printing errors and
+// warnings to stderr, and nothing else. If verbose is set, it also prints each
+// file copied to out.
+type quietProgress struct {
+	mu      sync.Mutex
+	failed  bool
+	verbose bool
+	out     io.Writer
+}
+
+func (*quietProgress) Max(int64)                       {}
+func (*quietProgress) Progress(int64)                  {}
+func (*quietProgress) FileStart(string, string, int64) {}
+
+func (qp *quietProgress) FileDone(src, dst string, err error) {
+	if err != nil {
+		qp.Error(err)
+		return
+	}
+	if qp.verbose {
+		qp.mu.Lock()
+		defer qp.mu.Unlock()
+		fmt.Fprintln(qp.out, src+" -> "+dst)
+	}
+}
+
+func (qp *quietProgress) Error(err error) {
+	qp.mu.Lock()
+	defer qp.mu.Unlock()
+	qp.failed = true
+	fmt.Fprintln(os.Stderr, err)
+}
+
+func (qp *quietProgress) Warning(err error) {
+	qp.mu.Lock()
+	defer qp.mu.Unlock()
+	fmt.Fprintln(os.Stderr, err)
+}
+
+// copyQuiet copies srcs to dst without showing any progress. If verbose is
+// set, the files copied are listed on verboseOut.
+func copyQuiet(ctx context.Context, srcs []cp.FSPath, dst cp.FSPath, opts cp.Options, verbose bool, verboseOut io.Writer) error {
+	qp := &quietProgress{verbose: verbose, out: verboseOut}
+	cp.Copy(ctx, qp, srcs, dst, opts)
+	if qp.failed {
+		return errCopyFailed
+	}
+	return nil
+}